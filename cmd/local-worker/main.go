@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 
 	"github.com/melslow/kitsune/pkg/activities"
 	"github.com/melslow/kitsune/pkg/activities/handlers"
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
+	"github.com/melslow/kitsune/pkg/tracing"
 	"github.com/melslow/kitsune/pkg/workflows"
 )
 
@@ -22,8 +28,38 @@ func main() {
 	if temporalAddress == "" {
 		temporalAddress = "localhost:7233"
 	}
+
+	// Temporal and Kitsune metrics are served for Prometheus on
+	// KITSUNE_METRICS_ADDR (e.g. ":9090") when it is set
+	metricsHandler := client.MetricsNopHandler
+	if addr := os.Getenv("KITSUNE_METRICS_ADDR"); addr != "" {
+		registry := metrics.NewRegistry()
+		handler, closer := metrics.NewHandler(registry)
+		defer closer.Close()
+		metricsHandler = handler
+		go func() {
+			log.Fatalln("Metrics endpoint failed:", metrics.Serve(addr, registry))
+		}()
+		log.Printf("Serving metrics on %s/metrics", addr)
+	}
+
+	// Workflows, activities and step handlers are traced with OpenTelemetry
+	// when an exporter is configured, see tracing.Setup
+	var interceptors []interceptor.ClientInterceptor
+	tracingInterceptor, shutdownTracing, err := tracing.Setup(context.Background(), "kitsune-local-worker", attribute.String("kitsune.server_id", serverID))
+	if err != nil {
+		log.Fatalln("Unable to set up tracing:", err)
+	}
+	defer shutdownTracing(context.Background())
+	if tracingInterceptor != nil {
+		interceptors = append(interceptors, tracingInterceptor)
+		log.Printf("Tracing enabled")
+	}
+
 	c, err := client.Dial(client.Options{
-		HostPort: temporalAddress,
+		HostPort:       temporalAddress,
+		MetricsHandler: metricsHandler,
+		Interceptors:   interceptors,
 	})
 	if err != nil {
 		log.Fatalln("Unable to create Temporal client:", err)
@@ -45,8 +81,21 @@ func main() {
 	w.RegisterWorkflow(workflows.ServerExecutionWorkflow)
 	w.RegisterWorkflow(workflows.ServerRollbackWorkflow)
 
+	// Every step run on this server is appended to the audit log at
+	// KITSUNE_AUDIT_LOG when it is set
+	var auditSink audit.Sink
+	if path := os.Getenv("KITSUNE_AUDIT_LOG"); path != "" {
+		fileSink, err := audit.NewFileSink(path)
+		if err != nil {
+			log.Fatalln("Unable to open audit log:", err)
+		}
+		defer fileSink.Close()
+		auditSink = fileSink
+		log.Printf("Recording steps to audit log: %s", path)
+	}
+
 	// Register activities
-	stepActivities := activities.NewStepActivities(serverID, registry)
+	stepActivities := activities.NewStepActivities(serverID, registry, auditSink)
 	w.RegisterActivity(stepActivities)
 
 	log.Printf("Local worker started for server: %s with %d registered handlers", serverID, 4)
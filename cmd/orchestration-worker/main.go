@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
 
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 
+	"github.com/melslow/kitsune/pkg/activities"
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
+	"github.com/melslow/kitsune/pkg/notify"
+	"github.com/melslow/kitsune/pkg/tracing"
 	"github.com/melslow/kitsune/pkg/workflows"
 )
 
@@ -16,9 +24,38 @@ func main() {
 		temporalAddress = "localhost:7233"
 	}
 
+	// Temporal and Kitsune metrics are served for Prometheus on
+	// KITSUNE_METRICS_ADDR (e.g. ":9090") when it is set
+	metricsHandler := client.MetricsNopHandler
+	if addr := os.Getenv("KITSUNE_METRICS_ADDR"); addr != "" {
+		registry := metrics.NewRegistry()
+		handler, closer := metrics.NewHandler(registry)
+		defer closer.Close()
+		metricsHandler = handler
+		go func() {
+			log.Fatalln("Metrics endpoint failed:", metrics.Serve(addr, registry))
+		}()
+		log.Printf("Serving metrics on %s/metrics", addr)
+	}
+
+	// Orchestrations are traced with OpenTelemetry when an exporter is
+	// configured, see tracing.Setup
+	var interceptors []interceptor.ClientInterceptor
+	tracingInterceptor, shutdownTracing, err := tracing.Setup(context.Background(), "kitsune-orchestrator")
+	if err != nil {
+		log.Fatalln("Unable to set up tracing:", err)
+	}
+	defer shutdownTracing(context.Background())
+	if tracingInterceptor != nil {
+		interceptors = append(interceptors, tracingInterceptor)
+		log.Printf("Tracing enabled")
+	}
+
 	// Connect to Temporal
 	c, err := client.Dial(client.Options{
-		HostPort: temporalAddress,
+		HostPort:       temporalAddress,
+		MetricsHandler: metricsHandler,
+		Interceptors:   interceptors,
 	})
 	if err != nil {
 		log.Fatalln("Unable to create Temporal client:", err)
@@ -31,6 +68,34 @@ func main() {
 	// Register ONLY orchestration workflow
 	w.RegisterWorkflow(workflows.OrchestrationWorkflow)
 
+	// Register orchestrator-side activities
+	w.RegisterActivity(activities.NewOrchestrationActivities(c))
+
+	// Lifecycle events are posted to every URL in KITSUNE_WEBHOOK_URLS (comma
+	// separated), signed with KITSUNE_WEBHOOK_SECRET when it is set
+	var sinks []notify.Sink
+	for _, url := range strings.Split(os.Getenv("KITSUNE_WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			sinks = append(sinks, notify.NewWebhookSink(url, os.Getenv("KITSUNE_WEBHOOK_SECRET")))
+		}
+	}
+	w.RegisterActivity(activities.NewNotificationActivities(sinks...))
+	log.Printf("Sending lifecycle events to %d webhooks", len(sinks))
+
+	// The steps run on every server are appended to the audit log at
+	// KITSUNE_AUDIT_LOG when it is set
+	var auditSink audit.Sink
+	if path := os.Getenv("KITSUNE_AUDIT_LOG"); path != "" {
+		fileSink, err := audit.NewFileSink(path)
+		if err != nil {
+			log.Fatalln("Unable to open audit log:", err)
+		}
+		defer fileSink.Close()
+		auditSink = fileSink
+		log.Printf("Recording steps to audit log: %s", path)
+	}
+	w.RegisterActivity(activities.NewAuditActivities(auditSink))
+
 	log.Printf("Central orchestrator worker started on queue: execution-orchestrator")
 
 	err = w.Run(worker.InterruptCh())
@@ -0,0 +1,28 @@
+package activities
+
+import (
+	"context"
+
+	"github.com/melslow/kitsune/pkg/audit"
+)
+
+// AuditActivities run on the orchestration worker and keep the orchestrator's
+// audit log of the steps run across all servers
+type AuditActivities struct {
+	sink audit.Sink
+}
+
+// NewAuditActivities records to sink, or discards the records if it is nil
+func NewAuditActivities(sink audit.Sink) *AuditActivities {
+	return &AuditActivities{
+		sink: sink,
+	}
+}
+
+// RecordAudit writes records to the audit log
+func (a *AuditActivities) RecordAudit(ctx context.Context, records []audit.Record) error {
+	if a.sink == nil {
+		return nil
+	}
+	return a.sink.Write(ctx, records...)
+}
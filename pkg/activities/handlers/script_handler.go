@@ -2,42 +2,111 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
-	
+	"slices"
+	"time"
+
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 
 	"github.com/melslow/kitsune/pkg/activities"
 	"github.com/melslow/kitsune/pkg/activities/params"
 )
 
+// defaultMaxOutputBytes caps each of stdout and stderr kept in the step result
+// of the server's workflow. The orchestrator leaves them out of its own result.
+const defaultMaxOutputBytes = 4 * 1024
+
+// errorStderrBytes is how much of the end of stderr is quoted in the error of a
+// failed script. The full captured stderr is in the error details.
+const errorStderrBytes = 256
+
 type ScriptParams struct {
 	Script         string   `json:"script" validate:"required"`
 	Args           []string `json:"args,omitempty"`
 	RollbackScript string   `json:"rollback_script,omitempty"`
+	// SuccessExitCodes are the exit codes the step succeeds with (default 0)
+	SuccessExitCodes []int `json:"success_exit_codes,omitempty"`
+	// ChangedExitCodes and UnchangedExitCodes are exit codes the step succeeds
+	// with and that report whether the script changed anything. When either is
+	// set, the outputs include changed.
+	ChangedExitCodes   []int `json:"changed_exit_codes,omitempty"`
+	UnchangedExitCodes []int `json:"unchanged_exit_codes,omitempty"`
+	// MaxOutputBytes caps each of stdout and stderr in the outputs, keeping the
+	// end of the output (default 4 KiB)
+	MaxOutputBytes int `json:"max_output_bytes,omitempty"`
 }
 
 type ScriptHandler struct{}
 
+// Execute runs the script and returns its exit_code, stdout, stderr and
+// duration_seconds as outputs, with stdout_truncated and stderr_truncated set
+// when output was cut to MaxOutputBytes. A failed script returns the same
+// outputs as the details of a ScriptFailed error.
 func (h *ScriptHandler) Execute(ctx context.Context, rawParams map[string]interface{}) (activities.ExecutionMetadata, error) {
 	var p ScriptParams
 	if err := params.ParseAndValidate(rawParams, &p); err != nil {
 		return nil, err
 	}
-	
+
 	logger := activity.GetLogger(ctx)
 	logger.Info("Running script", "script", p.Script)
-	
-	cmd := exec.CommandContext(ctx, p.Script, p.Args...)
-	output, err := cmd.CombinedOutput()
-	
-	logger.Info("Script completed", "output", string(output))
-	
-	if err != nil {
-		return nil, fmt.Errorf("script failed: %w, output: %s", err, string(output))
-	}
-	
-	return nil, nil
+
+	limit := p.MaxOutputBytes
+	if limit <= 0 {
+		limit = defaultMaxOutputBytes
+	}
+	stdout := &tailBuffer{limit: limit}
+	stderr := &tailBuffer{limit: limit}
+	cmd := exec.Command(p.Script, p.Args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// Heartbeat while the script runs so a lost worker is noticed early and a
+	// cancelled step kills the script with everything it started
+	started := time.Now()
+	err := activities.NewHeartbeater(ctx).Run(cmd)
+	duration := time.Since(started)
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		// The script did not start, or was stopped by cancellation
+		return nil, fmt.Errorf("script failed: %w", err)
+	}
+
+	exitCode := cmd.ProcessState.ExitCode()
+	metadata := activities.ExecutionMetadata{
+		"exit_code":        exitCode,
+		"stdout":           stdout.String(),
+		"stderr":           stderr.String(),
+		"stdout_truncated": stdout.Truncated(),
+		"stderr_truncated": stderr.Truncated(),
+		"duration_seconds": duration.Seconds(),
+	}
+	logger.Info("Script completed", "exitCode", exitCode, "duration", duration)
+
+	successCodes := p.SuccessExitCodes
+	if len(successCodes) == 0 {
+		successCodes = []int{0}
+	}
+	changed := slices.Contains(p.ChangedExitCodes, exitCode)
+	unchanged := slices.Contains(p.UnchangedExitCodes, exitCode)
+	if !changed && !unchanged && !slices.Contains(successCodes, exitCode) {
+		tail := stderr.String()
+		if len(tail) > errorStderrBytes {
+			tail = "..." + tail[len(tail)-errorStderrBytes:]
+		}
+		message := fmt.Sprintf("script failed with exit code %d, stderr: %s", exitCode, tail)
+		return nil, temporal.NewApplicationError(message, "ScriptFailed", metadata)
+	}
+
+	if len(p.ChangedExitCodes) > 0 || len(p.UnchangedExitCodes) > 0 {
+		// With only unchanged codes listed, any other success is a change
+		metadata["changed"] = changed || (!unchanged && len(p.ChangedExitCodes) == 0)
+	}
+	return metadata, nil
 }
 
 func (h *ScriptHandler) Rollback(ctx context.Context, rawParams map[string]interface{}, metadata activities.ExecutionMetadata) error {
@@ -45,14 +114,43 @@ func (h *ScriptHandler) Rollback(ctx context.Context, rawParams map[string]inter
 	if err := params.ParseAndValidate(rawParams, &p); err != nil {
 		return nil
 	}
-	
+
 	logger := activity.GetLogger(ctx)
 	if p.RollbackScript != "" {
 		logger.Info("Running rollback script", "script", p.RollbackScript)
-		cmd := exec.CommandContext(ctx, p.RollbackScript)
-		return cmd.Run()
+		return activities.NewHeartbeater(ctx).Run(exec.Command(p.RollbackScript))
 	}
-	
+
 	logger.Info("No rollback script specified")
 	return nil
-}
\ No newline at end of file
+}
+
+// tailBuffer keeps the last limit bytes written to it. Each stream has its own
+// buffer, written only by the goroutine copying that stream.
+type tailBuffer struct {
+	limit   int
+	data    []byte
+	dropped bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	// Trim only once the buffer has doubled, so writes stay amortised O(1)
+	if len(b.data) > 2*b.limit {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.limit:]...)
+		b.dropped = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	if len(b.data) > b.limit {
+		return string(b.data[len(b.data)-b.limit:])
+	}
+	return string(b.data)
+}
+
+// Truncated reports whether output was dropped from the start
+func (b *tailBuffer) Truncated() bool {
+	return b.dropped || len(b.data) > b.limit
+}
@@ -0,0 +1,157 @@
+//go:build unix
+
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/melslow/kitsune/pkg/activities"
+)
+
+func runScript(t *testing.T, script string, extra map[string]interface{}) (activities.ExecutionMetadata, error) {
+	t.Helper()
+	params := map[string]interface{}{
+		"script": "sh",
+		"args":   []interface{}{"-c", script},
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return executeScript(t, params)
+}
+
+// executeScript runs the handler in a test activity environment, so outputs go
+// through the same encoding as a real step result
+func executeScript(t *testing.T, params map[string]interface{}) (activities.ExecutionMetadata, error) {
+	t.Helper()
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivityWithOptions((&ScriptHandler{}).Execute, activity.RegisterOptions{Name: "Script"})
+
+	value, err := env.ExecuteActivity("Script", params)
+	if err != nil {
+		return nil, err
+	}
+	var metadata activities.ExecutionMetadata
+	if err := value.Get(&metadata); err != nil {
+		t.Fatalf("Failed to decode outputs: %v", err)
+	}
+	return metadata, nil
+}
+
+func TestScriptHandler_CapturesOutputs(t *testing.T) {
+	metadata, err := runScript(t, "echo installed; echo warning >&2", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if metadata["exit_code"] != float64(0) || metadata["stdout"] != "installed\n" || metadata["stderr"] != "warning\n" {
+		t.Errorf("Expected exit code and separate stdout and stderr, got %+v", metadata)
+	}
+	if d, ok := metadata["duration_seconds"].(float64); !ok || d <= 0 {
+		t.Errorf("Expected the duration, got %v", metadata["duration_seconds"])
+	}
+	if _, ok := metadata["changed"]; ok {
+		t.Errorf("Expected no changed output without changed or unchanged exit codes, got %+v", metadata)
+	}
+}
+
+func TestScriptHandler_FailureCarriesOutputs(t *testing.T) {
+	_, err := runScript(t, "echo disk full >&2; exit 3", nil)
+
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != "ScriptFailed" {
+		t.Fatalf("Expected a ScriptFailed error, got: %v", err)
+	}
+	var metadata map[string]interface{}
+	if err := appErr.Details(&metadata); err != nil {
+		t.Fatalf("Expected outputs in the error details: %v", err)
+	}
+	if metadata["exit_code"] != float64(3) || metadata["stderr"] != "disk full\n" {
+		t.Errorf("Expected the exit code and stderr, got %+v", metadata)
+	}
+}
+
+func TestScriptHandler_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		params  map[string]interface{}
+		fails   bool
+		changed interface{}
+	}{
+		{name: "extra success code", script: "exit 1", params: map[string]interface{}{"success_exit_codes": []interface{}{0, 1}}},
+		{name: "success codes replace 0", script: "exit 0", params: map[string]interface{}{"success_exit_codes": []interface{}{1}}, fails: true},
+		{name: "unchanged code", script: "exit 2", params: map[string]interface{}{"unchanged_exit_codes": []interface{}{2}}, changed: false},
+		{name: "other success with unchanged codes", script: "exit 0", params: map[string]interface{}{"unchanged_exit_codes": []interface{}{2}}, changed: true},
+		{name: "changed code", script: "exit 3", params: map[string]interface{}{"changed_exit_codes": []interface{}{3}}, changed: true},
+		{name: "other success with changed codes", script: "exit 0", params: map[string]interface{}{"changed_exit_codes": []interface{}{3}}, changed: false},
+		{name: "unlisted code", script: "exit 4", params: map[string]interface{}{"changed_exit_codes": []interface{}{3}}, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := runScript(t, tt.script, tt.params)
+			if tt.fails {
+				if err == nil {
+					t.Error("Expected the script to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if metadata["changed"] != tt.changed {
+				t.Errorf("Expected changed to be %v, got %v", tt.changed, metadata["changed"])
+			}
+		})
+	}
+}
+
+func TestScriptHandler_CapsOutput(t *testing.T) {
+	metadata, err := runScript(t, "printf 'abcdefghijklmnopqrstuvwxyz'", map[string]interface{}{"max_output_bytes": 10})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if metadata["stdout"] != "qrstuvwxyz" || metadata["stdout_truncated"] != true {
+		t.Errorf("Expected the last 10 bytes of stdout, got %q", metadata["stdout"])
+	}
+	if metadata["stderr_truncated"] != false {
+		t.Error("Expected stderr not to be truncated")
+	}
+}
+
+func TestScriptHandler_DefaultOutputCap(t *testing.T) {
+	_, err := runScript(t, "head -c 100000 /dev/zero | tr '\\0' o; head -c 100000 /dev/zero | tr '\\0' e >&2; exit 1", nil)
+
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("Expected a ScriptFailed error, got: %v", err)
+	}
+	if len(appErr.Message()) > 512 {
+		t.Errorf("Expected only the end of stderr in the error, got %d bytes", len(appErr.Message()))
+	}
+	var metadata map[string]interface{}
+	if err := appErr.Details(&metadata); err != nil {
+		t.Fatalf("Expected outputs in the error details: %v", err)
+	}
+	for _, stream := range []string{"stdout", "stderr"} {
+		if output, _ := metadata[stream].(string); len(output) != defaultMaxOutputBytes || metadata[stream+"_truncated"] != true {
+			t.Errorf("Expected %s to be cut to %d bytes, got %d", stream, defaultMaxOutputBytes, len(output))
+		}
+	}
+}
+
+func TestScriptHandler_MissingScript(t *testing.T) {
+	_, err := executeScript(t, map[string]interface{}{"script": "/nonexistent/script.sh"})
+	if err == nil || !strings.Contains(err.Error(), "script failed") {
+		t.Errorf("Expected the script to fail to start, got: %v", err)
+	}
+}
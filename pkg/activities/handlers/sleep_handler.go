@@ -16,6 +16,11 @@ type SleepParams struct {
 
 type SleepHandler struct{}
 
+// sleepProgress lets a retried sleep resume instead of starting over
+type sleepProgress struct {
+	Until time.Time `json:"until"`
+}
+
 func (h *SleepHandler) Execute(ctx context.Context, rawParams map[string]interface{}) (activities.ExecutionMetadata, error) {
 	var p SleepParams
 	if err := params.ParseAndValidate(rawParams, &p); err != nil {
@@ -25,8 +30,20 @@ func (h *SleepHandler) Execute(ctx context.Context, rawParams map[string]interfa
 	logger := activity.GetLogger(ctx)
 	duration := time.Duration(p.Duration) * time.Second
 	
+	heartbeater := activities.NewHeartbeater(ctx)
+	var progress sleepProgress
+	if heartbeater.Previous(&progress) {
+		duration = time.Until(progress.Until)
+		logger.Info("Resuming sleep of previous attempt", "until", progress.Until)
+	} else {
+		progress.Until = time.Now().Add(duration)
+	}
+	heartbeater.Progress(progress)
+	
 	logger.Info("Sleeping", "duration", duration)
-	time.Sleep(duration)
+	if err := heartbeater.Sleep(duration); err != nil {
+		return nil, err
+	}
 	logger.Info("Sleep completed")
 	
 	return nil, nil
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"fmt"
+	"time"
 	
 	"github.com/melslow/kitsune/pkg/activities/params"
+	"github.com/melslow/kitsune/pkg/expr"
 	"github.com/melslow/kitsune/pkg/models"
 )
 
@@ -22,21 +24,211 @@ func (v *StepValidator) ValidateStep(step models.StepDefinition) error {
 		return fmt.Errorf("unknown step type: %s", step.Type)
 	}
 	
+	// Templated parameters are only known once the step runs, so their
+	// values are checked by the handler then
+	templated, err := templatedParams(step.Params)
+	if err != nil {
+		return fmt.Errorf("validation failed for step '%s' (type: %s): %w", step.Name, step.Type, err)
+	}
+	
 	// Validate the parameters
-	if err := params.ParseAndValidate(step.Params, paramsStruct); err != nil {
+	if err := params.ParseAndValidateDeferred(step.Params, paramsStruct, templated); err != nil {
 		return fmt.Errorf("validation failed for step '%s' (type: %s): %w", step.Name, step.Type, err)
 	}
 	
+	if err := validateExecutionOptions(step); err != nil {
+		return fmt.Errorf("validation failed for step '%s' (type: %s): %w", step.Name, step.Type, err)
+	}
+	
+	return nil
+}
+
+// validateExecutionOptions checks the timeouts and retry policy of a step
+func validateExecutionOptions(step models.StepDefinition) error {
+	if err := validateDuration("timeout", step.Timeout); err != nil {
+		return err
+	}
+	if err := validateDuration("heartbeatTimeout", step.HeartbeatTimeout); err != nil {
+		return err
+	}
+	
+	retry := step.Retry
+	if retry == nil {
+		return nil
+	}
+	if retry.MaxAttempts < 0 {
+		return fmt.Errorf("retry.maxAttempts cannot be negative")
+	}
+	if retry.BackoffCoefficient != 0 && retry.BackoffCoefficient < 1 {
+		return fmt.Errorf("retry.backoffCoefficient must be at least 1")
+	}
+	if err := validateDuration("retry.initialInterval", retry.InitialInterval); err != nil {
+		return err
+	}
+	if err := validateDuration("retry.maxInterval", retry.MaxInterval); err != nil {
+		return err
+	}
+	if retry.InitialInterval != "" && retry.MaxInterval != "" {
+		initialInterval, _ := time.ParseDuration(retry.InitialInterval)
+		maxInterval, _ := time.ParseDuration(retry.MaxInterval)
+		if maxInterval < initialInterval {
+			return fmt.Errorf("retry.maxInterval cannot be less than retry.initialInterval")
+		}
+	}
+	return nil
+}
+
+// validateDuration checks that an optional duration such as "90s" is positive
+func validateDuration(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %q", name, value)
+	}
 	return nil
 }
 
-// ValidateSteps validates all steps in a list
+// ValidateSteps validates all steps in a list and the dependencies between them
 func (v *StepValidator) ValidateSteps(steps []models.StepDefinition) error {
 	for i, step := range steps {
 		if err := v.ValidateStep(step); err != nil {
 			return fmt.Errorf("step %d: %w", i+1, err)
 		}
 	}
+	if err := v.validateDependencies(steps); err != nil {
+		return err
+	}
+	
+	before := func(i int, name string) bool {
+		return runsBefore(steps, i, name)
+	}
+	if err := v.validateConditions(steps, before); err != nil {
+		return err
+	}
+	return v.validateTemplates(steps, before)
+}
+
+// ValidateFinally validates the finally steps that run after steps. They run
+// in order once all of steps have finished, so their conditions and templates
+// may refer to any of steps and to earlier finally steps.
+func (v *StepValidator) ValidateFinally(steps, finally []models.StepDefinition) error {
+	for i, step := range finally {
+		if err := v.ValidateStep(step); err != nil {
+			return fmt.Errorf("finally step %d: %w", i+1, err)
+		}
+		if len(step.DependsOn) > 0 {
+			return fmt.Errorf("finally step '%s': finally steps run in order and cannot use dependsOn", step.Name)
+		}
+	}
+	
+	before := func(i int, name string) bool {
+		for _, step := range steps {
+			if step.Name == name {
+				return true
+			}
+		}
+		for _, step := range finally[:i] {
+			if step.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	if err := v.validateConditions(finally, before); err != nil {
+		return err
+	}
+	return v.validateTemplates(finally, before)
+}
+
+// templatedParams returns the names of the parameters whose values contain templates
+func templatedParams(raw map[string]interface{}) (map[string]bool, error) {
+	var templated map[string]bool
+	for name, value := range raw {
+		templates, err := expr.Templates(value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		if len(templates) > 0 {
+			if templated == nil {
+				templated = make(map[string]bool)
+			}
+			templated[name] = true
+		}
+	}
+	return templated, nil
+}
+
+// validateDependencies checks that dependsOn only references other steps by
+// their unique names and that the dependencies do not form a cycle
+func (v *StepValidator) validateDependencies(steps []models.StepDefinition) error {
+	hasDependencies := false
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 {
+			hasDependencies = true
+			break
+		}
+	}
+	if !hasDependencies {
+		return nil
+	}
+	
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if step.Name == "" {
+			return fmt.Errorf("step %d: steps must be named when dependsOn is used", i+1)
+		}
+		if _, ok := index[step.Name]; ok {
+			return fmt.Errorf("duplicate step name '%s'", step.Name)
+		}
+		index[step.Name] = i
+	}
+	
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if dep == step.Name {
+				return fmt.Errorf("step '%s' depends on itself", step.Name)
+			}
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("step '%s' depends on unknown step '%s'", step.Name, dep)
+			}
+		}
+	}
+	
+	// Depth-first search for a dependency that leads back to a step being visited
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make([]int, len(steps))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch states[i] {
+		case visiting:
+			return fmt.Errorf("dependency cycle involving step '%s'", steps[i].Name)
+		case visited:
+			return nil
+		}
+		states[i] = visiting
+		for _, dep := range steps[i].DependsOn {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		states[i] = visited
+		return nil
+	}
+	for i := range steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	
 	return nil
 }
 
@@ -57,3 +249,117 @@ func (v *StepValidator) getParamsStructForType(stepType string) interface{} {
 		return nil
 	}
 }
+
+// Heartbeats reports whether the handler of a built-in step type heartbeats
+// while it runs, so that a heartbeat timeout can be applied to it by default
+func Heartbeats(stepType string) bool {
+	switch stepType {
+	case "script", "sleep", "yum_upgrade":
+		return true
+	default:
+		return false
+	}
+}
+
+// validateConditions checks that every When condition parses, only uses the
+// steps, vars and server variables, and only refers to steps that finish
+// before the step it guards, as reported by before
+func (v *StepValidator) validateConditions(steps []models.StepDefinition, before func(i int, name string) bool) error {
+	for i, step := range steps {
+		if step.When == "" {
+			continue
+		}
+		
+		condition, err := expr.Parse(step.When)
+		if err != nil {
+			return fmt.Errorf("step '%s': %w", step.Name, err)
+		}
+		
+		if err := checkReferences(step, "condition", condition.Paths(), func(name string) bool { return before(i, name) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTemplates checks the templates in step parameters the same way as
+// conditions, so a step only takes outputs from steps that finish before it
+func (v *StepValidator) validateTemplates(steps []models.StepDefinition, before func(i int, name string) bool) error {
+	for i, step := range steps {
+		templates, err := expr.Templates(step.Params)
+		if err != nil {
+			return fmt.Errorf("step '%s': %w", step.Name, err)
+		}
+		for _, t := range templates {
+			if err := checkReferences(step, "parameter", t.Paths(), func(name string) bool { return before(i, name) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferences checks the variable paths used by the condition or
+// parameters of a step
+func checkReferences(step models.StepDefinition, kind string, paths [][]string, before func(name string) bool) error {
+	for _, path := range paths {
+		switch path[0] {
+		case "vars", "server":
+		case "steps":
+			if len(path) < 2 {
+				return fmt.Errorf("step '%s': %s must name a step after \"steps\"", step.Name, kind)
+			}
+			if !before(path[1]) {
+				return fmt.Errorf("step '%s': %s refers to step '%s', which does not run before it", step.Name, kind, path[1])
+			}
+		default:
+			return fmt.Errorf("step '%s': %s refers to unknown variable '%s'", step.Name, kind, path[0])
+		}
+	}
+	return nil
+}
+
+// runsBefore reports whether the step named name always finishes before the
+// step at index i starts: an earlier step when steps run in order, or a direct
+// or indirect dependency when they form a graph
+func runsBefore(steps []models.StepDefinition, i int, name string) bool {
+	graph := false
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 {
+			graph = true
+			break
+		}
+	}
+	
+	if !graph {
+		for j := 0; j < i; j++ {
+			if steps[j].Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	
+	index := make(map[string]int, len(steps))
+	for j, step := range steps {
+		index[step.Name] = j
+	}
+	
+	// Walk the dependencies of step i; validateDependencies has ruled out cycles
+	seen := make(map[int]bool)
+	pending := []int{i}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range steps[current].DependsOn {
+			if dep == name {
+				return true
+			}
+			if j := index[dep]; !seen[j] {
+				seen[j] = true
+				pending = append(pending, j)
+			}
+		}
+	}
+	return false
+}
@@ -294,3 +294,324 @@ func TestStepValidator_ValidateSteps_ErrorInSecondStep(t *testing.T) {
 		t.Errorf("Expected unsupported parameters error, got: %v", err)
 	}
 }
+
+func TestStepValidator_ValidateSteps_Dependencies(t *testing.T) {
+	validator := NewStepValidator()
+	
+	echo := func(name string, dependsOn ...string) models.StepDefinition {
+		return models.StepDefinition{
+			Name:      name,
+			Type:      "echo",
+			Params:    map[string]interface{}{"message": name},
+			DependsOn: dependsOn,
+		}
+	}
+	
+	tests := []struct {
+		name     string
+		steps    []models.StepDefinition
+		expected string
+	}{
+		{
+			name:  "valid graph",
+			steps: []models.StepDefinition{echo("fetch-a"), echo("fetch-b"), echo("install", "fetch-a", "fetch-b")},
+		},
+		{
+			name:     "unknown dependency",
+			steps:    []models.StepDefinition{echo("install", "fetch")},
+			expected: "depends on unknown step 'fetch'",
+		},
+		{
+			name:     "duplicate names",
+			steps:    []models.StepDefinition{echo("fetch"), echo("fetch"), echo("install", "fetch")},
+			expected: "duplicate step name 'fetch'",
+		},
+		{
+			name:     "self dependency",
+			steps:    []models.StepDefinition{echo("install", "install")},
+			expected: "depends on itself",
+		},
+		{
+			name:     "cycle",
+			steps:    []models.StepDefinition{echo("a", "c"), echo("b", "a"), echo("c", "b")},
+			expected: "dependency cycle",
+		},
+		{
+			name:  "duplicate names without dependencies",
+			steps: []models.StepDefinition{echo("restart"), echo("restart")},
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateSteps(tt.steps)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStepValidator_ValidateSteps_Conditions(t *testing.T) {
+	validator := NewStepValidator()
+	
+	step := func(name string, when string, dependsOn ...string) models.StepDefinition {
+		return models.StepDefinition{
+			Name:      name,
+			Type:      "echo",
+			Params:    map[string]interface{}{"message": name},
+			When:      when,
+			DependsOn: dependsOn,
+		}
+	}
+	
+	tests := []struct {
+		name     string
+		steps    []models.StepDefinition
+		expected string
+	}{
+		{
+			name:  "earlier step, vars and server",
+			steps: []models.StepDefinition{step("upgrade", ""), step("restart", "steps.upgrade.outputs.changed && vars.restart != false && server.labels.zone == 'a'")},
+		},
+		{
+			name:     "later step",
+			steps:    []models.StepDefinition{step("restart", "steps.upgrade.outputs.changed"), step("upgrade", "")},
+			expected: "does not run before it",
+		},
+		{
+			name:  "indirect dependency in a graph",
+			steps: []models.StepDefinition{step("fetch", ""), step("upgrade", "", "fetch"), step("restart", "steps.fetch.status == 'succeeded'", "upgrade")},
+		},
+		{
+			name:     "unrelated step in a graph",
+			steps:    []models.StepDefinition{step("fetch", ""), step("upgrade", "", "fetch"), step("restart", "steps.fetch.status == 'succeeded'")},
+			expected: "does not run before it",
+		},
+		{
+			name:     "unknown variable",
+			steps:    []models.StepDefinition{step("restart", "env.HOME == '/root'")},
+			expected: "unknown variable 'env'",
+		},
+		{
+			name:     "syntax error",
+			steps:    []models.StepDefinition{step("restart", "vars.restart ==")},
+			expected: "invalid expression",
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateSteps(tt.steps)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStepValidator_ValidateSteps_Templates(t *testing.T) {
+	validator := NewStepValidator()
+	
+	echo := func(name string, message interface{}, dependsOn ...string) models.StepDefinition {
+		return models.StepDefinition{
+			Name:      name,
+			Type:      "echo",
+			Params:    map[string]interface{}{"message": message},
+			DependsOn: dependsOn,
+		}
+	}
+	
+	tests := []struct {
+		name     string
+		steps    []models.StepDefinition
+		expected string
+	}{
+		{
+			name:  "output of an earlier step",
+			steps: []models.StepDefinition{echo("fetch-version", "fetch"), echo("install", "installing {{ steps.fetch-version.outputs.version }} on {{ server.id }}")},
+		},
+		{
+			name: "templated value of another type",
+			steps: []models.StepDefinition{
+				echo("fetch", "fetch"),
+				{Name: "wait", Type: "sleep", Params: map[string]interface{}{"duration": "{{ steps.fetch.outputs.delay }}"}},
+			},
+		},
+		{
+			name:     "later step",
+			steps:    []models.StepDefinition{echo("install", "{{ steps.fetch-version.outputs.version }}"), echo("fetch-version", "fetch")},
+			expected: "parameter refers to step 'fetch-version', which does not run before it",
+		},
+		{
+			name:     "step that is not a dependency in a graph",
+			steps:    []models.StepDefinition{echo("fetch", "fetch"), echo("other", "other", "fetch"), echo("install", "{{ steps.other.outputs.version }}")},
+			expected: "does not run before it",
+		},
+		{
+			name:     "unknown variable",
+			steps:    []models.StepDefinition{echo("install", "{{ vars.version || env.VERSION }}")},
+			expected: "unknown variable 'env'",
+		},
+		{
+			name: "format string in script args",
+			steps: []models.StepDefinition{
+				{Name: "inspect", Type: "script", Params: map[string]interface{}{"script": "/usr/bin/docker", "args": []interface{}{"inspect", "-f", "{{.State.Running}}", "web"}}},
+			},
+		},
+		{
+			name: "template in file content",
+			steps: []models.StepDefinition{
+				{Name: "write", Type: "file_write", Params: map[string]interface{}{"path": "/etc/motd", "content": "Hello {{ .Name }}"}},
+			},
+		},
+		{
+			name:     "unclosed placeholder",
+			steps:    []models.StepDefinition{echo("install", "{{ vars.version")},
+			expected: "unclosed",
+		},
+		{
+			name:     "templated but unsupported parameter",
+			steps:    []models.StepDefinition{{Name: "install", Type: "echo", Params: map[string]interface{}{"message": "hi", "extra": "{{ vars.x }}"}}},
+			expected: "unsupported parameters: extra",
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateSteps(tt.steps)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStepValidator_ValidateStep_ExecutionOptions(t *testing.T) {
+	validator := NewStepValidator()
+	
+	tests := []struct {
+		name     string
+		step     models.StepDefinition
+		expected string
+	}{
+		{
+			name: "valid options",
+			step: models.StepDefinition{Timeout: "30m", HeartbeatTimeout: "30s", Retry: &models.StepRetryPolicy{
+				MaxAttempts: 1, InitialInterval: "5s", BackoffCoefficient: 1.5, MaxInterval: "1m", NonRetryableErrorTypes: []string{"ScriptFailed"},
+			}},
+		},
+		{
+			name:     "unparsable timeout",
+			step:     models.StepDefinition{Timeout: "30"},
+			expected: "invalid timeout",
+		},
+		{
+			name:     "negative heartbeat timeout",
+			step:     models.StepDefinition{HeartbeatTimeout: "-5s"},
+			expected: "heartbeatTimeout must be positive",
+		},
+		{
+			name:     "negative attempts",
+			step:     models.StepDefinition{Retry: &models.StepRetryPolicy{MaxAttempts: -1}},
+			expected: "maxAttempts cannot be negative",
+		},
+		{
+			name:     "shrinking backoff",
+			step:     models.StepDefinition{Retry: &models.StepRetryPolicy{BackoffCoefficient: 0.5}},
+			expected: "backoffCoefficient must be at least 1",
+		},
+		{
+			name:     "max interval below initial interval",
+			step:     models.StepDefinition{Retry: &models.StepRetryPolicy{InitialInterval: "1m", MaxInterval: "10s"}},
+			expected: "maxInterval cannot be less than",
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step.Name = "step"
+			tt.step.Type = "echo"
+			tt.step.Params = map[string]interface{}{"message": "hello"}
+			
+			err := validator.ValidateStep(tt.step)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStepValidator_ValidateFinally(t *testing.T) {
+	validator := NewStepValidator()
+	
+	step := func(name string, message string) models.StepDefinition {
+		return models.StepDefinition{Name: name, Type: "echo", Params: map[string]interface{}{"message": message}}
+	}
+	steps := []models.StepDefinition{step("drain", "drain"), step("upgrade", "upgrade")}
+	
+	tests := []struct {
+		name     string
+		finally  []models.StepDefinition
+		expected string
+	}{
+		{
+			name:    "refers to main and earlier finally steps",
+			finally: []models.StepDefinition{step("undrain", "{{ steps.drain.status }}"), step("notify", "{{ steps.undrain.status }} {{ steps.upgrade.status }}")},
+		},
+		{
+			name:     "refers to a later finally step",
+			finally:  []models.StepDefinition{step("notify", "{{ steps.undrain.status }}"), step("undrain", "undrain")},
+			expected: "refers to step 'undrain', which does not run before it",
+		},
+		{
+			name:     "invalid params",
+			finally:  []models.StepDefinition{{Name: "undrain", Type: "echo"}},
+			expected: "finally step 1",
+		},
+		{
+			name:     "dependsOn",
+			finally:  []models.StepDefinition{{Name: "undrain", Type: "echo", Params: map[string]interface{}{"message": "undrain"}, DependsOn: []string{"drain"}}},
+			expected: "cannot use dependsOn",
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateFinally(steps, tt.finally)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
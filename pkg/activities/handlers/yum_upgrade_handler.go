@@ -6,10 +6,12 @@ import (
 	"os/exec"
 	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.temporal.io/sdk/activity"
 
 	"github.com/melslow/kitsune/pkg/activities"
 	"github.com/melslow/kitsune/pkg/activities/params"
+	"github.com/melslow/kitsune/pkg/tracing"
 )
 
 type YumUpgradeParams struct {
@@ -19,6 +21,12 @@ type YumUpgradeParams struct {
 
 type YumUpgradeHandler struct{}
 
+// yumUpgradeProgress is carried in heartbeats so a retried upgrade knows the
+// version installed before the first attempt
+type yumUpgradeProgress struct {
+	PreviousVersion string `json:"previousVersion"`
+}
+
 func (h *YumUpgradeHandler) Execute(ctx context.Context, rawParams map[string]interface{}) (activities.ExecutionMetadata, error) {
 	var p YumUpgradeParams
 	if err := params.ParseAndValidate(rawParams, &p); err != nil {
@@ -29,22 +37,30 @@ func (h *YumUpgradeHandler) Execute(ctx context.Context, rawParams map[string]in
 	logger.Info("Starting yum upgrade", "package", p.Package, "version", p.Version)
 
 	metadata := make(activities.ExecutionMetadata)
-
-	// Get currently installed version for rollback
-	cmd := exec.CommandContext(ctx, "rpm", "-q", p.Package, "--queryformat", "%{VERSION}-%{RELEASE}")
-	output, err := cmd.CombinedOutput()
-	if err == nil {
-		previousVersion := strings.TrimSpace(string(output))
-		metadata["previous_version"] = previousVersion
-		logger.Info("Captured current version for rollback", "package", p.Package, "currentVersion", previousVersion)
+	heartbeater := activities.NewHeartbeater(ctx)
+
+	// Get currently installed version for rollback. A retried upgrade reuses
+	// the version captured by the first attempt, as an interrupted yum may
+	// already have replaced the package.
+	var progress yumUpgradeProgress
+	if heartbeater.Previous(&progress) && progress.PreviousVersion != "" {
+		metadata["previous_version"] = progress.PreviousVersion
+		heartbeater.Progress(progress)
+		logger.Info("Reusing version captured by previous attempt", "package", p.Package, "currentVersion", progress.PreviousVersion)
 	} else {
-		logger.Warn("Could not get current version", "package", p.Package, "error", err.Error())
+		previousVersion, err := installedVersion(ctx, p.Package)
+		if err == nil {
+			metadata["previous_version"] = previousVersion
+			heartbeater.Progress(yumUpgradeProgress{PreviousVersion: previousVersion})
+			logger.Info("Captured current version for rollback", "package", p.Package, "currentVersion", previousVersion)
+		} else {
+			logger.Warn("Could not get current version", "package", p.Package, "error", err.Error())
+		}
 	}
 
 	// Perform the upgrade
 	fullPackage := fmt.Sprintf("%s-%s", p.Package, p.Version)
-	cmd = exec.CommandContext(ctx, "yum", "upgrade", "-y", fullPackage)
-	output, err = cmd.CombinedOutput()
+	output, err := heartbeater.CombinedOutput(exec.Command("yum", "upgrade", "-y", fullPackage))
 
 	logger.Info("Yum upgrade completed", "output", string(output))
 
@@ -52,6 +68,16 @@ func (h *YumUpgradeHandler) Execute(ctx context.Context, rawParams map[string]in
 		return metadata, fmt.Errorf("yum upgrade failed: %w, output: %s", err, string(output))
 	}
 
+	// Record whether the upgrade changed anything, so later steps can be made
+	// conditional on it (e.g. only restart the service when it did)
+	newVersion, err := installedVersion(ctx, p.Package)
+	if err == nil {
+		metadata["new_version"] = newVersion
+		metadata["changed"] = newVersion != metadata["previous_version"]
+	} else {
+		logger.Warn("Could not get new version", "package", p.Package, "error", err.Error())
+	}
+
 	return metadata, nil
 }
 
@@ -71,14 +97,12 @@ func (h *YumUpgradeHandler) Rollback(ctx context.Context, rawParams map[string]i
 	logger.Info("Starting rollback for package", "package", p.Package, "targetVersion", previousVersion)
 
 	// Check current installed version to make intelligent rollback decision
-	cmd := exec.CommandContext(ctx, "rpm", "-q", p.Package, "--queryformat", "%{VERSION}-%{RELEASE}")
-	output, err := cmd.CombinedOutput()
+	currentVersion, err := installedVersion(ctx, p.Package)
 
 	if err != nil {
 		// Package might not be installed or in inconsistent state
 		logger.Warn("Could not query current package version, attempting rollback anyway", "package", p.Package, "error", err.Error())
 	} else {
-		currentVersion := strings.TrimSpace(string(output))
 		logger.Info("Current package version", "package", p.Package, "version", currentVersion)
 
 		// If current version is already the previous version, no rollback needed
@@ -96,8 +120,7 @@ func (h *YumUpgradeHandler) Rollback(ctx context.Context, rawParams map[string]i
 
 	// Downgrade to previous version
 	fullPackage := fmt.Sprintf("%s-%s", p.Package, previousVersion)
-	cmd = exec.CommandContext(ctx, "yum", "downgrade", "-y", fullPackage)
-	output, err = cmd.CombinedOutput()
+	output, err := activities.NewHeartbeater(ctx).CombinedOutput(exec.Command("yum", "downgrade", "-y", fullPackage))
 
 	logger.Info("Yum downgrade completed", "output", string(output))
 
@@ -106,10 +129,8 @@ func (h *YumUpgradeHandler) Rollback(ctx context.Context, rawParams map[string]i
 	}
 
 	// Verify rollback was successful
-	cmd = exec.CommandContext(ctx, "rpm", "-q", p.Package, "--queryformat", "%{VERSION}-%{RELEASE}")
-	output, err = cmd.CombinedOutput()
+	finalVersion, err := installedVersion(ctx, p.Package)
 	if err == nil {
-		finalVersion := strings.TrimSpace(string(output))
 		if finalVersion == previousVersion {
 			logger.Info("Rollback verified successful", "package", p.Package, "version", finalVersion)
 		} else {
@@ -119,3 +140,15 @@ func (h *YumUpgradeHandler) Rollback(ctx context.Context, rawParams map[string]i
 
 	return nil
 }
+
+// installedVersion queries rpm for the installed version-release of pkg
+func installedVersion(ctx context.Context, pkg string) (version string, err error) {
+	ctx, span := tracing.Start(ctx, "rpm query", attribute.String("package", pkg))
+	defer func() { tracing.End(span, err) }()
+
+	output, err := exec.CommandContext(ctx, "rpm", "-q", pkg, "--queryformat", "%{VERSION}-%{RELEASE}").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,176 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/sdk/activity"
+
+	"github.com/melslow/kitsune/pkg/tracing"
+)
+
+// defaultHeartbeatInterval is used when the activity has no heartbeat timeout
+const defaultHeartbeatInterval = 10 * time.Second
+
+// Heartbeater records activity heartbeats on behalf of a step handler. Every
+// heartbeat carries the latest progress details, which Temporal hands to the
+// next attempt when the step is retried. Outside of an activity, as in handler
+// unit tests, heartbeats are skipped and only cancellation of ctx is honoured.
+type Heartbeater struct {
+	ctx      context.Context
+	interval time.Duration
+
+	mu      sync.Mutex
+	details interface{}
+}
+
+func NewHeartbeater(ctx context.Context) *Heartbeater {
+	h := &Heartbeater{
+		ctx:      ctx,
+		interval: defaultHeartbeatInterval,
+	}
+	if activity.IsActivity(ctx) {
+		// Beat well within the heartbeat timeout; the SDK throttles anything faster
+		if timeout := activity.GetInfo(ctx).HeartbeatTimeout; timeout > 0 && timeout/2 < h.interval {
+			h.interval = timeout / 2
+		}
+	}
+	return h
+}
+
+// Beat records a heartbeat with the current progress details
+func (h *Heartbeater) Beat() {
+	if !activity.IsActivity(h.ctx) {
+		return
+	}
+	h.mu.Lock()
+	details := h.details
+	h.mu.Unlock()
+
+	if details == nil {
+		activity.RecordHeartbeat(h.ctx)
+		return
+	}
+	activity.RecordHeartbeat(h.ctx, details)
+}
+
+// Progress replaces the progress details and records a heartbeat with them
+func (h *Heartbeater) Progress(details interface{}) {
+	h.mu.Lock()
+	h.details = details
+	h.mu.Unlock()
+	h.Beat()
+}
+
+// Previous decodes into target the progress details last recorded by a
+// previous attempt of the step. It reports false on the first attempt.
+func (h *Heartbeater) Previous(target interface{}) bool {
+	if !activity.IsActivity(h.ctx) || !activity.HasHeartbeatDetails(h.ctx) {
+		return false
+	}
+	if err := activity.GetHeartbeatDetails(h.ctx, target); err != nil {
+		activity.GetLogger(h.ctx).Warn("Could not decode progress of previous attempt", "error", err)
+		return false
+	}
+	return true
+}
+
+// Sleep waits for d while heartbeating. It returns early with the context's
+// error when the activity is cancelled.
+func (h *Heartbeater) Sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+			h.Beat()
+		case <-h.ctx.Done():
+			return h.ctx.Err()
+		}
+	}
+}
+
+// Run starts cmd in its own process group and waits for it, heartbeating while
+// it runs. When the activity is cancelled or times out, the whole process
+// group is killed, so processes spawned by a script do not outlive the step.
+// cmd must not have been created with exec.CommandContext. The command is
+// traced as a child span of the activity.
+func (h *Heartbeater) Run(cmd *exec.Cmd) error {
+	_, span := tracing.Start(h.ctx, "exec "+filepath.Base(cmd.Path), attribute.String("process.executable.path", cmd.Path))
+	err := h.run(cmd)
+	if cmd.ProcessState != nil {
+		span.SetAttributes(attribute.Int("process.exit.code", cmd.ProcessState.ExitCode()))
+	}
+	tracing.End(span, err)
+	return err
+}
+
+func (h *Heartbeater) run(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	h.Beat()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			h.Beat()
+		case <-h.ctx.Done():
+			if err := killProcessGroup(cmd); err != nil && activity.IsActivity(h.ctx) {
+				activity.GetLogger(h.ctx).Warn("Failed to kill process group", "pid", cmd.Process.Pid, "error", err)
+			}
+			<-done
+			return fmt.Errorf("%s stopped: %w", cmd.Path, h.ctx.Err())
+		}
+	}
+}
+
+// CombinedOutput is Run returning the combined stdout and stderr of cmd, like
+// exec.Cmd.CombinedOutput
+func (h *Heartbeater) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output syncBuffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := h.Run(cmd)
+	return output.Bytes(), err
+}
+
+// syncBuffer collects output written concurrently by stdout and stderr copiers
+type syncBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.data...)
+}
@@ -0,0 +1,150 @@
+//go:build unix
+
+package activities
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestHeartbeater_CombinedOutput(t *testing.T) {
+	h := NewHeartbeater(context.Background())
+
+	output, err := h.CombinedOutput(exec.Command("sh", "-c", "echo out; echo err >&2"))
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if got := string(output); !strings.Contains(got, "out\n") || !strings.Contains(got, "err\n") {
+		t.Errorf("Expected stdout and stderr, got %q", got)
+	}
+}
+
+func TestHeartbeater_RunKillsProcessGroupOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHeartbeater(ctx)
+
+	// The script starts a background process, which must be killed along with it
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo $! > "+pidFile+"; wait")
+
+	result := make(chan error, 1)
+	go func() {
+		result <- h.Run(cmd)
+	}()
+
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Background process did not start")
+		}
+		if data, err := os.ReadFile(pidFile); err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected cancellation error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+
+	// The background process is gone, or a zombie waiting to be reaped
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Background process %d survived cancellation", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHeartbeater_RunIsTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	err := NewHeartbeater(context.Background()).Run(exec.Command("sh", "-c", "exit 3"))
+	if err == nil {
+		t.Fatal("Expected the command to fail")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "exec sh" {
+		t.Fatalf("Expected an exec span, got %v", spans)
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("Expected the span to be marked failed, got %v", spans[0].Status())
+	}
+	var exitCode int64
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "process.exit.code" {
+			exitCode = attr.Value.AsInt64()
+		}
+	}
+	if exitCode != 3 {
+		t.Errorf("Expected exit code 3 on the span, got %d", exitCode)
+	}
+}
+
+func TestHeartbeater_PreviousProgress(t *testing.T) {
+	type progress struct {
+		Phase string `json:"phase"`
+	}
+	resume := func(ctx context.Context) (string, error) {
+		var p progress
+		if !NewHeartbeater(ctx).Previous(&p) {
+			return "first attempt", nil
+		}
+		return p.Phase, nil
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	for _, tt := range []struct {
+		details  interface{}
+		expected string
+	}{
+		{details: nil, expected: "first attempt"},
+		{details: progress{Phase: "upgrading"}, expected: "upgrading"},
+	} {
+		env := suite.NewTestActivityEnvironment()
+		env.RegisterActivity(resume)
+		if tt.details != nil {
+			env.SetHeartbeatDetails(tt.details)
+		}
+
+		value, err := env.ExecuteActivity(resume)
+		if err != nil {
+			t.Fatalf("Activity failed: %v", err)
+		}
+		var got string
+		if err := value.Get(&got); err != nil {
+			t.Fatalf("Failed to get result: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
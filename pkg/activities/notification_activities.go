@@ -0,0 +1,73 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/melslow/kitsune/pkg/notify"
+)
+
+// NotificationActivities run on the orchestration worker and deliver
+// orchestration lifecycle events to the configured sinks
+type NotificationActivities struct {
+	sinks []notify.Sink
+}
+
+func NewNotificationActivities(sinks ...notify.Sink) *NotificationActivities {
+	return &NotificationActivities{
+		sinks: sinks,
+	}
+}
+
+// notifyProgress records the sinks an event was delivered to, so a retry only
+// sends it to the ones that failed
+type notifyProgress struct {
+	Delivered []bool `json:"delivered"`
+}
+
+// Notify delivers event to every sink. It fails when any sink failed, and
+// fails without retries when every failure is permanent.
+func (a *NotificationActivities) Notify(ctx context.Context, event notify.Event) error {
+	logger := activity.GetLogger(ctx)
+
+	heartbeater := NewHeartbeater(ctx)
+	var progress notifyProgress
+	if !heartbeater.Previous(&progress) || len(progress.Delivered) != len(a.sinks) {
+		progress.Delivered = make([]bool, len(a.sinks))
+	}
+
+	var errs []error
+	permanent := true
+	for i, sink := range a.sinks {
+		if progress.Delivered[i] {
+			continue
+		}
+		if err := sink.Send(ctx, event); err != nil {
+			logger.Warn("Failed to deliver event", "type", event.Type, "id", event.ID, "error", err)
+			errs = append(errs, err)
+
+			var statusErr *notify.StatusError
+			if !errors.As(err, &statusErr) || !statusErr.Permanent() {
+				permanent = false
+			}
+			continue
+		}
+		progress.Delivered[i] = true
+		heartbeater.Progress(progress)
+	}
+
+	if len(errs) == 0 {
+		logger.Info("Delivered event", "type", event.Type, "id", event.ID, "sinks", len(a.sinks))
+		return nil
+	}
+
+	err := fmt.Errorf("failed to deliver %s event to %d of %d sinks: %w", event.Type, len(errs), len(a.sinks), errors.Join(errs...))
+	if permanent {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "NotificationRejected", err)
+	}
+	return err
+}
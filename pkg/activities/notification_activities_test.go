@@ -0,0 +1,61 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/melslow/kitsune/pkg/notify"
+)
+
+type fakeSink struct {
+	err  error
+	sent int
+}
+
+func (s *fakeSink) Send(ctx context.Context, event notify.Event) error {
+	s.sent++
+	return s.err
+}
+
+func TestNotify_RetrySkipsDeliveredSinks(t *testing.T) {
+	delivered := &fakeSink{}
+	failing := &fakeSink{err: errors.New("connection refused")}
+	a := NewNotificationActivities(delivered, failing)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(a)
+	// A previous attempt already reached the first sink
+	env.SetHeartbeatDetails(notifyProgress{Delivered: []bool{true, false}})
+
+	_, err := env.ExecuteActivity(a.Notify, notify.Event{Type: notify.EventServerFailed})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 sinks") {
+		t.Fatalf("Expected the failing sink to fail the activity, got: %v", err)
+	}
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.NonRetryable() {
+		t.Error("Expected a connection failure to be retried")
+	}
+	if delivered.sent != 0 || failing.sent != 1 {
+		t.Errorf("Expected only the failing sink to be sent to, got %d and %d", delivered.sent, failing.sent)
+	}
+}
+
+func TestNotify_PermanentFailureIsNotRetried(t *testing.T) {
+	a := NewNotificationActivities(&fakeSink{err: &notify.StatusError{URL: "http://hooks", StatusCode: 404}})
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(a)
+
+	_, err := env.ExecuteActivity(a.Notify, notify.Event{Type: notify.EventServerFailed})
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || !appErr.NonRetryable() {
+		t.Errorf("Expected a non-retryable error, got: %v", err)
+	}
+}
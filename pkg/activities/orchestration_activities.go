@@ -0,0 +1,76 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// OrchestrationActivities run on the orchestration worker and give workflows
+// access to other orchestrations through the Temporal client
+type OrchestrationActivities struct {
+	client client.Client
+}
+
+func NewOrchestrationActivities(c client.Client) *OrchestrationActivities {
+	return &OrchestrationActivities{
+		client: c,
+	}
+}
+
+// LoadOrchestration returns the effective request and the result of a finished orchestration.
+// The result of a failed or aborted orchestration is read from its error details.
+func (a *OrchestrationActivities) LoadOrchestration(ctx context.Context, ref models.OrchestrationRef) (*models.OrchestrationRecord, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Loading orchestration", "workflowID", ref.WorkflowID, "runID", ref.RunID)
+
+	desc, err := a.client.DescribeWorkflowExecution(ctx, ref.WorkflowID, ref.RunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe orchestration %s: %w", ref.WorkflowID, err)
+	}
+
+	info := desc.GetWorkflowExecutionInfo()
+	if info.GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("orchestration %s is still running", ref.WorkflowID), "OrchestrationRunning", nil)
+	}
+
+	record := &models.OrchestrationRecord{
+		Ref: models.OrchestrationRef{
+			WorkflowID: ref.WorkflowID,
+			RunID:      info.GetExecution().GetRunId(),
+		},
+	}
+
+	payload, ok := info.GetMemo().GetFields()[models.RequestMemoKey]
+	if !ok {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("orchestration %s has no recorded request", ref.WorkflowID), "OrchestrationNotRetryable", nil)
+	}
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &record.Request); err != nil {
+		return nil, fmt.Errorf("failed to decode request of orchestration %s: %w", ref.WorkflowID, err)
+	}
+
+	err = a.client.GetWorkflow(ctx, ref.WorkflowID, record.Ref.RunID).Get(ctx, &record.Result)
+	if err != nil {
+		var appErr *temporal.ApplicationError
+		if !errors.As(err, &appErr) || !appErr.HasDetails() {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("orchestration %s has no result: %v", ref.WorkflowID, err), "OrchestrationNotRetryable", err)
+		}
+		if err := appErr.Details(&record.Result); err != nil {
+			return nil, fmt.Errorf("failed to decode result of orchestration %s: %w", ref.WorkflowID, err)
+		}
+	}
+
+	logger.Info("Loaded orchestration", "workflowID", ref.WorkflowID, "runID", record.Ref.RunID, "servers", len(record.Request.Servers))
+	return record, nil
+}
@@ -9,6 +9,13 @@ import (
 
 // ParseAndValidate parses raw parameters into a typed struct and validates that no unsupported parameters are present
 func ParseAndValidate(raw map[string]interface{}, target interface{}) error {
+	return ParseAndValidateDeferred(raw, target, nil)
+}
+
+// ParseAndValidateDeferred is ParseAndValidate for parameters whose values are
+// only known at execution time: the deferred parameters must be supported, but
+// are neither parsed nor checked for presence
+func ParseAndValidateDeferred(raw map[string]interface{}, target interface{}, deferred map[string]bool) error {
 	if raw == nil {
 		return fmt.Errorf("parameters cannot be nil")
 	}
@@ -28,8 +35,18 @@ func ParseAndValidate(raw map[string]interface{}, target interface{}) error {
 		return fmt.Errorf("unsupported parameters: %s", strings.Join(unsupportedParams, ", "))
 	}
 
+	known := raw
+	if len(deferred) > 0 {
+		known = make(map[string]interface{}, len(raw))
+		for key, value := range raw {
+			if !deferred[key] {
+				known[key] = value
+			}
+		}
+	}
+
 	// Parse parameters into target struct
-	jsonData, err := json.Marshal(raw)
+	jsonData, err := json.Marshal(known)
 	if err != nil {
 		return fmt.Errorf("failed to marshal parameters: %w", err)
 	}
@@ -39,7 +56,7 @@ func ParseAndValidate(raw map[string]interface{}, target interface{}) error {
 	}
 
 	// Validate required fields
-	if err := validateRequired(target); err != nil {
+	if err := validateRequired(target, deferred); err != nil {
 		return err
 	}
 
@@ -69,8 +86,8 @@ func getSupportedParams(target interface{}) map[string]bool {
 	return supported
 }
 
-// validateRequired checks that all required fields are set
-func validateRequired(target interface{}) error {
+// validateRequired checks that all required fields, other than deferred ones, are set
+func validateRequired(target interface{}, deferred map[string]bool) error {
 	v := reflect.ValueOf(target)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -85,13 +102,8 @@ func validateRequired(target interface{}) error {
 			fieldValue := v.Field(i)
 			
 			// Check if field is zero value
-			if isZeroValue(fieldValue) {
-				jsonTag := field.Tag.Get("json")
-				name := strings.Split(jsonTag, ",")[0]
-				if name == "" {
-					name = field.Name
-				}
-				return fmt.Errorf("missing required parameter: %s", name)
+			if isZeroValue(fieldValue) && !deferred[jsonName(field)] {
+				return fmt.Errorf("missing required parameter: %s", jsonName(field))
 			}
 		}
 	}
@@ -99,6 +111,15 @@ func validateRequired(target interface{}) error {
 	return nil
 }
 
+// jsonName returns the parameter name of a struct field
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
 // isZeroValue checks if a reflect.Value is the zero value for its type
 func isZeroValue(v reflect.Value) bool {
 	switch v.Kind() {
@@ -94,3 +94,23 @@ func TestParseAndValidate_NilParams(t *testing.T) {
 		t.Errorf("Expected 'parameters cannot be nil', got: %v", err)
 	}
 }
+
+func TestParseAndValidateDeferred(t *testing.T) {
+	raw := map[string]interface{}{
+		"required": 42, // not a string yet, e.g. an unresolved template
+		"optional": "value",
+	}
+
+	var p TestParams
+	if err := ParseAndValidateDeferred(raw, &p, map[string]bool{"required": true}); err != nil {
+		t.Errorf("Expected deferred parameter to be skipped, got: %v", err)
+	}
+	if p.Optional != "value" {
+		t.Errorf("Expected optional 'value', got '%s'", p.Optional)
+	}
+
+	raw["unknown"] = "{{ vars.x }}"
+	if err := ParseAndValidateDeferred(raw, &p, map[string]bool{"required": true, "unknown": true}); err == nil {
+		t.Error("Expected error for deferred but unsupported parameter")
+	}
+}
@@ -3,26 +3,33 @@ package activities
 import (
 	"context"
 	"fmt"
+	"time"
 	
 	"go.temporal.io/sdk/activity"
 	
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
 	"github.com/melslow/kitsune/pkg/models"
 )
 
 type StepActivities struct {
 	serverID string
 	registry *StepHandlerRegistry
+	audit    audit.Sink
 }
 
-func NewStepActivities(serverID string, registry *StepHandlerRegistry) *StepActivities {
+// NewStepActivities creates the activities a local worker runs steps with.
+// Every step executed or rolled back is recorded to auditSink, if not nil.
+func NewStepActivities(serverID string, registry *StepHandlerRegistry, auditSink audit.Sink) *StepActivities {
 	return &StepActivities{
 		serverID: serverID,
 		registry: registry,
+		audit:    auditSink,
 	}
 }
 
 // ExecuteStep executes a single step using the handler registry
-func (a *StepActivities) ExecuteStep(ctx context.Context, serverID string, step models.StepDefinition) (ExecutionMetadata, error) {
+func (a *StepActivities) ExecuteStep(ctx context.Context, serverID string, step models.StepDefinition, auditInfo models.AuditInfo) (ExecutionMetadata, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Executing step", "name", step.Name, "type", step.Type)
 	
@@ -31,17 +38,33 @@ func (a *StepActivities) ExecuteStep(ctx context.Context, serverID string, step
 		return nil, fmt.Errorf("no handler registered for step type: %s", step.Type)
 	}
 	
+	record, err := a.auditStarted(ctx, audit.ActionExecute, serverID, step, auditInfo)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Add serverID to params
 	if step.Params == nil {
 		step.Params = make(map[string]interface{})
 	}
 	step.Params["server_id"] = serverID
 	
-	return handler.Execute(ctx, step.Params)
+	started := time.Now()
+	metadata, err := handler.Execute(ctx, step.Params)
+	
+	tagged := activity.GetMetricsHandler(ctx).WithTags(map[string]string{
+		metrics.TagStepType: step.Type,
+		metrics.TagOutcome:  metrics.Outcome(err),
+	})
+	tagged.Counter(metrics.StepsExecuted).Inc(1)
+	tagged.Timer(metrics.StepDuration).Record(time.Since(started))
+	
+	a.auditFinished(ctx, record, metadata, err)
+	return metadata, err
 }
 
 // RollbackStep rolls back a step
-func (a *StepActivities) RollbackStep(ctx context.Context, serverID string, step models.StepDefinition, metadata ExecutionMetadata) error {
+func (a *StepActivities) RollbackStep(ctx context.Context, serverID string, step models.StepDefinition, metadata ExecutionMetadata, auditInfo models.AuditInfo) error {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Rolling back step", "name", step.Name, "type", step.Type)
 	
@@ -51,10 +74,79 @@ func (a *StepActivities) RollbackStep(ctx context.Context, serverID string, step
 		return nil
 	}
 	
+	record, err := a.auditStarted(ctx, audit.ActionRollback, serverID, step, auditInfo)
+	if err != nil {
+		return err
+	}
+	
 	if step.Params == nil {
 		step.Params = make(map[string]interface{})
 	}
 	step.Params["server_id"] = serverID
 	
-	return handler.Rollback(ctx, step.Params, metadata)
-}
\ No newline at end of file
+	err = handler.Rollback(ctx, step.Params, metadata)
+	activity.GetMetricsHandler(ctx).WithTags(map[string]string{
+		metrics.TagStepType: step.Type,
+		metrics.TagOutcome:  metrics.Outcome(err),
+	}).Counter(metrics.StepRollbacks).Inc(1)
+	a.auditFinished(ctx, record, nil, err)
+	return err
+}
+
+// auditStarted records that a step is about to run. A step that cannot be
+// audited does not run, so the activity fails and is retried.
+func (a *StepActivities) auditStarted(ctx context.Context, action string, serverID string, step models.StepDefinition, auditInfo models.AuditInfo) (audit.Record, error) {
+	if a.audit == nil {
+		return audit.Record{}, nil
+	}
+	
+	info := activity.GetInfo(ctx)
+	now := time.Now()
+	record := audit.Record{
+		Time:       now,
+		RecordedBy: a.serverID,
+		Phase:      audit.PhaseStarted,
+		Action:     action,
+		PlanID:     auditInfo.PlanID,
+		Requester:  auditInfo.Requester,
+		WorkflowID: info.WorkflowExecution.ID,
+		Attempt:    info.Attempt,
+		ServerID:   serverID,
+		Step:       step.Name,
+		StepType:   step.Type,
+		ParamsHash: audit.Digest(step.Params),
+		StartedAt:  &now,
+	}
+	// Steps started outside an orchestration are their own plan
+	if record.PlanID == "" {
+		record.PlanID = info.WorkflowExecution.ID
+	}
+	
+	if err := a.audit.Write(ctx, record); err != nil {
+		return record, fmt.Errorf("failed to audit step %s: %w", step.Name, err)
+	}
+	return record, nil
+}
+
+// auditFinished records the outcome of a step. The step has already run, so a
+// failure to record it is only logged.
+func (a *StepActivities) auditFinished(ctx context.Context, record audit.Record, metadata ExecutionMetadata, err error) {
+	if a.audit == nil {
+		return
+	}
+	
+	now := time.Now()
+	record.Time = now
+	record.Phase = audit.PhaseFinished
+	record.FinishedAt = &now
+	record.OutputDigest = audit.Digest(metadata)
+	record.Result = audit.ResultSucceeded
+	if err != nil {
+		record.Result = audit.ResultFailed
+		record.Error = err.Error()
+	}
+	
+	if writeErr := a.audit.Write(ctx, record); writeErr != nil {
+		activity.GetLogger(ctx).Error("Failed to audit step outcome", "step", record.Step, "error", writeErr)
+	}
+}
@@ -0,0 +1,169 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+type fakeAuditSink struct {
+	err     error
+	records []audit.Record
+}
+
+func (s *fakeAuditSink) Write(ctx context.Context, records ...audit.Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+type recordingHandler struct {
+	executed bool
+}
+
+func (h *recordingHandler) Execute(ctx context.Context, params map[string]interface{}) (ExecutionMetadata, error) {
+	h.executed = true
+	return ExecutionMetadata{"previous_version": "1.0"}, nil
+}
+
+func (h *recordingHandler) Rollback(ctx context.Context, params map[string]interface{}, metadata ExecutionMetadata) error {
+	return errors.New("downgrade failed")
+}
+
+func newAuditedActivities(sink audit.Sink) (*StepActivities, *recordingHandler, *testsuite.TestActivityEnvironment) {
+	handler := &recordingHandler{}
+	registry := NewStepHandlerRegistry()
+	registry.Register("upgrade", handler)
+	a := NewStepActivities("web-1", registry, sink)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(a)
+	return a, handler, env
+}
+
+func TestExecuteStep_Audited(t *testing.T) {
+	sink := &fakeAuditSink{}
+	a, _, env := newAuditedActivities(sink)
+
+	step := models.StepDefinition{Name: "upgrade-nginx", Type: "upgrade", Params: map[string]interface{}{"package": "nginx"}}
+	info := models.AuditInfo{PlanID: "patch-42", Requester: "alice"}
+	if _, err := env.ExecuteActivity(a.ExecuteStep, "web-1", step, info); err != nil {
+		t.Fatalf("ExecuteStep failed: %v", err)
+	}
+
+	if len(sink.records) != 2 {
+		t.Fatalf("Expected started and finished records, got %+v", sink.records)
+	}
+	started, finished := sink.records[0], sink.records[1]
+	if started.Phase != audit.PhaseStarted || finished.Phase != audit.PhaseFinished {
+		t.Errorf("Expected started then finished, got %s then %s", started.Phase, finished.Phase)
+	}
+	if finished.PlanID != "patch-42" || finished.Requester != "alice" || finished.ServerID != "web-1" || finished.Step != "upgrade-nginx" {
+		t.Errorf("Expected the plan, requester, server and step, got %+v", finished)
+	}
+	// The hash covers the parameters as given, not the injected server_id
+	if finished.ParamsHash != audit.Digest(step.Params) {
+		t.Errorf("Expected the hash of the step parameters, got %s", finished.ParamsHash)
+	}
+	if finished.Result != audit.ResultSucceeded || finished.OutputDigest == "" || finished.StartedAt == nil || finished.FinishedAt == nil {
+		t.Errorf("Expected the outcome of the step, got %+v", finished)
+	}
+}
+
+func TestExecuteStep_NotRunWhenAuditFails(t *testing.T) {
+	a, handler, env := newAuditedActivities(&fakeAuditSink{err: errors.New("disk full")})
+
+	step := models.StepDefinition{Name: "upgrade-nginx", Type: "upgrade"}
+	if _, err := env.ExecuteActivity(a.ExecuteStep, "web-1", step, models.AuditInfo{}); err == nil {
+		t.Fatal("Expected ExecuteStep to fail")
+	}
+	if handler.executed {
+		t.Error("Expected the step not to run unaudited")
+	}
+}
+
+func TestRollbackStep_AuditsFailure(t *testing.T) {
+	sink := &fakeAuditSink{}
+	a, _, env := newAuditedActivities(sink)
+
+	step := models.StepDefinition{Name: "upgrade-nginx", Type: "upgrade"}
+	if _, err := env.ExecuteActivity(a.RollbackStep, "web-1", step, ExecutionMetadata{}, models.AuditInfo{}); err == nil {
+		t.Fatal("Expected RollbackStep to fail")
+	}
+
+	if len(sink.records) != 2 {
+		t.Fatalf("Expected started and finished records, got %+v", sink.records)
+	}
+	finished := sink.records[1]
+	if finished.Action != audit.ActionRollback || finished.Result != audit.ResultFailed || finished.Error == "" {
+		t.Errorf("Expected a failed rollback record, got %+v", finished)
+	}
+	// Without an orchestration the workflow is the plan
+	if finished.PlanID == "" || finished.PlanID != finished.WorkflowID {
+		t.Errorf("Expected the workflow ID as the plan ID, got %+v", finished)
+	}
+}
+
+func TestExecuteStep_RecordsMetrics(t *testing.T) {
+	prometheusRegistry := prometheus.NewRegistry()
+	var suite testsuite.WorkflowTestSuite
+	handler, closer := metrics.NewHandler(prometheusRegistry)
+	suite.SetMetricsHandler(handler)
+	env := suite.NewTestActivityEnvironment()
+
+	registry := NewStepHandlerRegistry()
+	registry.Register("upgrade", &recordingHandler{})
+	a := NewStepActivities("web-1", registry, nil)
+	env.RegisterActivity(a)
+
+	step := models.StepDefinition{Name: "upgrade-nginx", Type: "upgrade"}
+	for range 2 {
+		if _, err := env.ExecuteActivity(a.ExecuteStep, "web-1", step, models.AuditInfo{}); err != nil {
+			t.Fatalf("ExecuteStep failed: %v", err)
+		}
+	}
+	if _, err := env.ExecuteActivity(a.RollbackStep, "web-1", step, ExecutionMetadata{}, models.AuditInfo{}); err == nil {
+		t.Fatal("Expected RollbackStep to fail")
+	}
+	closer.Close()
+
+	families, err := prometheusRegistry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels[metrics.TagStepType] != "upgrade" {
+				continue
+			}
+			key := family.GetName() + "/" + labels[metrics.TagOutcome]
+			got[key] += metric.GetCounter().GetValue() + float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+
+	expected := map[string]float64{
+		metrics.StepsExecuted + "_total/succeeded":  2,
+		metrics.StepDuration + "_seconds/succeeded": 2,
+		metrics.StepRollbacks + "_total/failed":     1,
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, got)
+		}
+	}
+}
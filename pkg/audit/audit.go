@@ -0,0 +1,80 @@
+// Package audit records who ran which step, where, when, with which
+// parameters and what happened, for compliance. Local workers record every
+// step they execute or roll back; the orchestrator records the outcome of
+// every server it dispatched to.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Actions
+const (
+	ActionExecute  = "execute"
+	ActionRollback = "rollback"
+)
+
+// Phases of a record. A local worker writes a started record before a step
+// runs and a finished record after, so a step is never run unaudited. The
+// orchestrator writes one reported record per step from the server results.
+const (
+	PhaseStarted  = "started"
+	PhaseFinished = "finished"
+	PhaseReported = "reported"
+)
+
+// Results
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultSkipped   = "skipped"
+)
+
+// Record is one entry of the audit log
+type Record struct {
+	Time       time.Time `json:"time"`
+	RecordedBy string    `json:"recordedBy"` // server ID of the local worker, or "orchestrator"
+	Phase      string    `json:"phase"`
+	Action     string    `json:"action"`
+
+	PlanID     string `json:"planId"`
+	Requester  string `json:"requester,omitempty"`
+	WorkflowID string `json:"workflowId,omitempty"`
+	Attempt    int32  `json:"attempt,omitempty"`
+
+	ServerID   string `json:"serverId"`
+	Step       string `json:"step"`
+	StepType   string `json:"stepType,omitempty"`
+	ParamsHash string `json:"paramsHash,omitempty"`
+
+	StartedAt    *time.Time `json:"startedAt,omitempty"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	Result       string     `json:"result,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	OutputDigest string     `json:"outputDigest,omitempty"`
+}
+
+// Sink stores audit records
+type Sink interface {
+	Write(ctx context.Context, records ...Record) error
+}
+
+// Digest returns "sha256:<hex>" of the JSON encoding of values, which is
+// stable as encoding/json sorts map keys, or "" when there are none. It is used
+// for step parameters and outputs, so the log identifies what a step ran with
+// and produced without storing secrets.
+func Digest(values map[string]interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink appends records to a file as JSON lines. The file is only ever
+// appended to, and every write is synced to disk before it returns.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends records in a single write, so concurrent writers never
+// interleave partial lines
+func (s *FileSink) Write(ctx context.Context, records ...Record) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to encode audit record: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
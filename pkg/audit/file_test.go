@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink_AppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Records written before a restart are kept
+	for _, step := range []string{"drain", "upgrade"} {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatalf("NewFileSink failed: %v", err)
+		}
+		if err := sink.Write(context.Background(), Record{Step: step, Phase: PhaseStarted}, Record{Step: step, Phase: PhaseFinished}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		sink.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var got []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Expected a JSON record per line, got %q: %v", scanner.Text(), err)
+		}
+		got = append(got, record.Step+"/"+record.Phase)
+	}
+
+	expected := []string{"drain/started", "drain/finished", "upgrade/started", "upgrade/finished"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected records %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected records %v, got %v", expected, got)
+			break
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := Digest(map[string]interface{}{"package": "nginx", "version": "1.2"})
+	b := Digest(map[string]interface{}{"version": "1.2", "package": "nginx"})
+	if a == "" || a != b {
+		t.Errorf("Expected equal maps to have the same digest, got %q and %q", a, b)
+	}
+	if Digest(map[string]interface{}{"package": "httpd", "version": "1.2"}) == a {
+		t.Error("Expected different values to have different digests")
+	}
+	if Digest(nil) != "" {
+		t.Error("Expected no digest without values")
+	}
+}
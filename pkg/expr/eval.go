@@ -0,0 +1,186 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+	walk(fn func(node))
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n literalNode) walk(fn func(node)) {
+	fn(n)
+}
+
+type pathNode []string
+
+func (n pathNode) eval(env map[string]interface{}) (interface{}, error) {
+	value, _ := Lookup(env, n)
+	return value, nil
+}
+
+func (n pathNode) walk(fn func(node)) {
+	fn(n)
+}
+
+func (n pathNode) String() string {
+	return strings.Join(n, ".")
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(env map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !Truthy(value), nil
+}
+
+func (n notNode) walk(fn func(node)) {
+	fn(n)
+	n.operand.walk(fn)
+}
+
+// logicalNode is && or ||, short-circuiting like in Go
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicalNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" && !Truthy(left) {
+		return false, nil
+	}
+	if n.op == "||" && Truthy(left) {
+		return true, nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return Truthy(right), nil
+}
+
+func (n logicalNode) walk(fn func(node)) {
+	fn(n)
+	n.left.walk(fn)
+	n.right.walk(fn)
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+
+	cmp, err := order(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func (n compareNode) walk(fn func(node)) {
+	fn(n)
+	n.left.walk(fn)
+	n.right.walk(fn)
+}
+
+// equal compares values of any type; numbers compare by value regardless of
+// their Go type, and values of different kinds are never equal
+func equal(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// order compares two numbers or two strings
+func order(a, b interface{}) (int, error) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot order %v (%T) and %v (%T)", a, a, b, b)
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,116 @@
+// Package expr evaluates the small, side-effect free expressions used in step
+// conditions and in the {{ }} placeholders of step parameters. An expression
+// combines variable paths, literals, comparisons and boolean operators:
+//
+//	steps.upgrade.outputs.changed == true && server.labels.zone != "us-east-1a"
+//
+// Paths are looked up in nested maps; a missing path evaluates to null.
+// Supported operators are ==, !=, <, <=, >, >=, &&, || and !, with
+// parentheses for grouping. Literals are numbers, 'single' or "double" quoted
+// strings, true, false and null.
+package expr
+
+import (
+	"fmt"
+)
+
+// Expression is a parsed expression
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse compiles an expression
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against env
+func (e *Expression) Eval(env map[string]interface{}) (interface{}, error) {
+	value, err := e.root.eval(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %q: %w", e.source, err)
+	}
+	return value, nil
+}
+
+// EvalBool evaluates the expression against env and reports whether the
+// result is truthy
+func (e *Expression) EvalBool(env map[string]interface{}) (bool, error) {
+	value, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(value), nil
+}
+
+// Paths returns every variable path the expression references
+func (e *Expression) Paths() [][]string {
+	var paths [][]string
+	e.root.walk(func(n node) {
+		if p, ok := n.(pathNode); ok {
+			paths = append(paths, p)
+		}
+	})
+	return paths
+}
+
+// Truthy reports whether a value counts as true: null, false, zero and the
+// empty string are false, everything else is true
+func Truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if n, ok := toNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+// Lookup resolves a path in nested maps, reporting whether it exists
+func Lookup(env map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = env
+	for _, key := range path {
+		switch m := current.(type) {
+		case map[string]interface{}:
+			value, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case map[string]string:
+			value, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
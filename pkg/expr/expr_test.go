@@ -0,0 +1,226 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEnv() map[string]interface{} {
+	return map[string]interface{}{
+		"steps": map[string]interface{}{
+			"yum-upgrade": map[string]interface{}{
+				"status": "succeeded",
+				"outputs": map[string]interface{}{
+					"changed":     true,
+					"new_version": "1.20.1-1",
+					"count":       float64(3),
+				},
+			},
+		},
+		"vars": map[string]interface{}{
+			"restart": false,
+			"limit":   10,
+		},
+		"server": map[string]interface{}{
+			"id":     "web-1",
+			"labels": map[string]string{"zone": "us-east-1a"},
+		},
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{expression: "steps.yum-upgrade.outputs.changed", expected: true},
+		{expression: "steps.yum-upgrade.outputs.changed == true", expected: true},
+		{expression: "steps.yum-upgrade.status == 'succeeded'", expected: true},
+		{expression: `steps.yum-upgrade.outputs.new_version != "1.20.1-1"`, expected: false},
+		{expression: "steps.yum-upgrade.outputs.count >= 3 && vars.limit > 5", expected: true},
+		{expression: "vars.restart || server.labels.zone == 'us-east-1a'", expected: true},
+		{expression: "!vars.restart", expected: true},
+		{expression: "!(vars.restart || vars.limit == 10)", expected: false},
+		{expression: "vars.missing", expected: false},
+		{expression: "vars.missing == null", expected: true},
+		{expression: "steps.other.status == 'succeeded'", expected: false},
+		{expression: "server.id < 'web-2'", expected: true},
+		{expression: "vars.limit == 10.0", expected: true},
+		{expression: "-1 < 0", expected: true},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.expression)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		got, err := e.EvalBool(testEnv())
+		if err != nil {
+			t.Errorf("EvalBool(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("EvalBool(%q) = %v, expected %v", tt.expression, got, tt.expected)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	invalid := []string{
+		"",
+		"steps.",
+		"vars.limit ==",
+		"(vars.limit",
+		"vars.limit = 10",
+		"'unterminated",
+		"vars.limit 10",
+	}
+
+	for _, expression := range invalid {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected Parse(%q) to fail", expression)
+		}
+	}
+}
+
+func TestEval_OrderingMismatchedTypes(t *testing.T) {
+	e, err := Parse("server.id > 3")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, err := e.Eval(testEnv()); err == nil || !strings.Contains(err.Error(), "cannot order") {
+		t.Errorf("Expected ordering error, got: %v", err)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	e, err := Parse("steps.fetch.outputs.version != vars.version && !server.labels.canary")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := [][]string{
+		{"steps", "fetch", "outputs", "version"},
+		{"vars", "version"},
+		{"server", "labels", "canary"},
+	}
+	if got := e.Paths(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, got)
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	tests := []struct {
+		template string
+		expected interface{}
+	}{
+		{template: "{{ steps.yum-upgrade.outputs.new_version }}", expected: "1.20.1-1"},
+		{template: "{{steps.yum-upgrade.outputs.count}}", expected: float64(3)},
+		{template: "{{ steps.yum-upgrade.outputs.changed }}", expected: true},
+		{template: "nginx-{{ steps.yum-upgrade.outputs.new_version }}", expected: "nginx-1.20.1-1"},
+		{template: "{{ server.id }} in {{ server.labels.zone }}: {{ vars.limit }} {{ vars.restart }}", expected: "web-1 in us-east-1a: 10 false"},
+		{template: "no placeholders", expected: "no placeholders"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if err != nil {
+			t.Errorf("ParseTemplate(%q) failed: %v", tt.template, err)
+			continue
+		}
+		got, err := tmpl.Render(testEnv())
+		if err != nil {
+			t.Errorf("Render(%q) failed: %v", tt.template, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Render(%q) = %#v, expected %#v", tt.template, got, tt.expected)
+		}
+	}
+}
+
+func TestTemplateRender_MissingValue(t *testing.T) {
+	tmpl, err := ParseTemplate("version {{ steps.other.outputs.version }}")
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+	if _, err := tmpl.Render(testEnv()); err == nil || !strings.Contains(err.Error(), "has no value") {
+		t.Errorf("Expected missing value error, got: %v", err)
+	}
+}
+
+func TestParseTemplate_Errors(t *testing.T) {
+	for _, template := range []string{"{{ vars.version", "{{ vars. }}", "{{ vars.version == }}"} {
+		if _, err := ParseTemplate(template); err == nil {
+			t.Errorf("Expected ParseTemplate(%q) to fail", template)
+		}
+	}
+}
+
+func TestTemplate_OtherBracesAreLiteral(t *testing.T) {
+	tests := []struct {
+		template string
+		expected interface{}
+	}{
+		{template: "{{.State.Running}}", expected: "{{.State.Running}}"},
+		{template: "Hello {{ .Name }}", expected: "Hello {{ .Name }}"},
+		{template: "Hello {{ name }} from {{ server.id }}", expected: "Hello {{ name }} from web-1"},
+		{template: "{{ }} and {{", expected: "{{ }} and {{"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if err != nil {
+			t.Errorf("ParseTemplate(%q) failed: %v", tt.template, err)
+			continue
+		}
+		got, err := tmpl.Render(testEnv())
+		if err != nil {
+			t.Errorf("Render(%q) failed: %v", tt.template, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Render(%q) = %#v, expected %#v", tt.template, got, tt.expected)
+		}
+	}
+
+	for _, s := range []string{"{{.State.Running}}", "Hello {{ name }}", "{{ environment.name }}"} {
+		if IsTemplate(s) {
+			t.Errorf("Expected %q not to be a template", s)
+		}
+	}
+}
+
+func TestRenderValue(t *testing.T) {
+	value := map[string]interface{}{
+		"package": "nginx",
+		"version": "{{ steps.yum-upgrade.outputs.new_version }}",
+		"args":    []interface{}{"--count={{ steps.yum-upgrade.outputs.count }}", "-v"},
+		"retries": float64(2),
+	}
+
+	got, err := RenderValue(value, testEnv())
+	if err != nil {
+		t.Fatalf("RenderValue failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"package": "nginx",
+		"version": "1.20.1-1",
+		"args":    []interface{}{"--count=3", "-v"},
+		"retries": float64(2),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	templates, err := Templates(value)
+	if err != nil {
+		t.Fatalf("Templates failed: %v", err)
+	}
+	if len(templates) != 2 {
+		t.Errorf("Expected 2 templates, got %d", len(templates))
+	}
+}
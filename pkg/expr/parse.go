@@ -0,0 +1,259 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenDot
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{} // literal value of number and string tokens
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// isIdentRune reports whether r may appear in a path segment. Dashes are
+// allowed since step names commonly contain them.
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "("})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")"})
+			i++
+
+		case r == '.':
+			tokens = append(tokens, token{kind: tokenDot, text: "."})
+			i++
+
+		case r == '\'' || r == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[i : j+1]), value: b.String()})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || (runes[j] == '.' && j+1 < len(runes) && unicode.IsDigit(runes[j+1]))) {
+				j++
+			}
+			text := string(runes[i:j])
+			n, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: n})
+			i = j
+
+		case isIdentRune(r):
+			j := i
+			for j < len(runes) && isIdentRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[i:j])})
+			i = j
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand ]
+//	operand    = literal | path | "(" or ")"
+//	path       = ident { "." ident }
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) acceptOperator(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.acceptOperator("!"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOperator("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return literalNode{value: t.value}, nil
+
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		}
+
+		path := pathNode{t.text}
+		for p.peek().kind == tokenDot {
+			p.next()
+			segment := p.next()
+			// Segments may also be numbers, e.g. outputs.0
+			if segment.kind != tokenIdent && segment.kind != tokenNumber {
+				return nil, fmt.Errorf("expected name after \".\", got %s", segment)
+			}
+			path = append(path, segment.text)
+		}
+		return path, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %s", t)
+}
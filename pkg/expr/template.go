@@ -0,0 +1,267 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Template is a string with {{ expression }} placeholders, such as
+// "{{ steps.fetch-version.outputs.version }}" or "nginx-{{ vars.version }}".
+// A placeholder's expression starts with steps., vars. or server.
+type Template struct {
+	source string
+	parts  []templatePart
+}
+
+// templatePart is either literal text or an expression
+type templatePart struct {
+	literal    string
+	expression *Expression
+}
+
+// placeholderRoots are the variables a placeholder starts with. Other {{ }}
+// text, such as a Docker format string or a Jinja file body, is left as it is.
+var placeholderRoots = []string{"steps.", "vars.", "server."}
+
+// IsTemplate reports whether s contains a placeholder
+func IsTemplate(s string) bool {
+	start, _ := nextPlaceholder(s)
+	return start >= 0
+}
+
+// nextPlaceholder returns the offsets of the opening braces of the first
+// placeholder in s and of the end of its expression, or -1 when there is
+// none. The end is -1 when the placeholder is not closed.
+func nextPlaceholder(s string) (start, end int) {
+	offset := 0
+	for {
+		i := strings.Index(s[offset:], "{{")
+		if i < 0 {
+			return -1, -1
+		}
+		start = offset + i
+
+		inner := s[start+2:]
+		end = strings.Index(inner, "}}")
+		if end >= 0 {
+			inner = inner[:end]
+		}
+		if isPlaceholder(inner) {
+			if end < 0 {
+				return start, -1
+			}
+			return start, start + 2 + end
+		}
+		offset = start + 2
+	}
+}
+
+func isPlaceholder(inner string) bool {
+	inner = strings.TrimSpace(inner)
+	for _, root := range placeholderRoots {
+		if strings.HasPrefix(inner, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTemplate compiles a template
+func ParseTemplate(source string) (*Template, error) {
+	t := &Template{source: source}
+
+	rest := source
+	for {
+		start, end := nextPlaceholder(rest)
+		if start < 0 {
+			if rest != "" {
+				t.parts = append(t.parts, templatePart{literal: rest})
+			}
+			return t, nil
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("invalid template %q: unclosed {{", source)
+		}
+		expression, err := Parse(rest[start+2 : end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q: %w", source, err)
+		}
+		t.parts = append(t.parts, templatePart{expression: expression})
+		rest = rest[end+2:]
+	}
+}
+
+// String returns the source of the template
+func (t *Template) String() string {
+	return t.source
+}
+
+// Paths returns every variable path the template references
+func (t *Template) Paths() [][]string {
+	var paths [][]string
+	for _, part := range t.parts {
+		if part.expression != nil {
+			paths = append(paths, part.expression.Paths()...)
+		}
+	}
+	return paths
+}
+
+// Render evaluates the template against env. A template that is a single
+// placeholder yields the value itself, keeping its type; otherwise the values
+// are formatted into the surrounding text. A placeholder without a value is
+// an error.
+func (t *Template) Render(env map[string]interface{}) (interface{}, error) {
+	if len(t.parts) == 1 && t.parts[0].expression != nil {
+		return renderPart(t.parts[0].expression, env)
+	}
+
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.expression == nil {
+			b.WriteString(part.literal)
+			continue
+		}
+
+		value, err := renderPart(part.expression, env)
+		if err != nil {
+			return nil, err
+		}
+		text, err := format(value)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(text)
+	}
+	return b.String(), nil
+}
+
+func renderPart(expression *Expression, env map[string]interface{}) (interface{}, error) {
+	value, err := expression.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("{{ %s }} has no value", expression)
+	}
+	return value, nil
+}
+
+func format(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	if n, ok := toNumber(value); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("cannot format %T: %w", value, err)
+	}
+	return string(data), nil
+}
+
+// RenderValue renders the templates in every string nested in value, which
+// may be made of maps and slices as decoded from JSON. Strings without
+// placeholders are returned unchanged.
+func RenderValue(value interface{}, env map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !IsTemplate(v) {
+			return v, nil
+		}
+		t, err := ParseTemplate(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.Render(env)
+
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := RenderValue(item, env)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := RenderValue(item, env)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+
+	case []string:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := RenderValue(item, env)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+
+	return value, nil
+}
+
+// Templates returns the templates found in every string nested in value
+func Templates(value interface{}) ([]*Template, error) {
+	var templates []*Template
+
+	var collect func(value interface{}) error
+	collect = func(value interface{}) error {
+		switch v := value.(type) {
+		case string:
+			if IsTemplate(v) {
+				t, err := ParseTemplate(v)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, t)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				if err := collect(item); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				if err := collect(item); err != nil {
+					return err
+				}
+			}
+		case []string:
+			for _, item := range v {
+				if err := collect(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := collect(value); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
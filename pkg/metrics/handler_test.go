@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// find returns the series of the named metric with the given label values
+func find(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			if len(metric.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue series
+				}
+			}
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestHandler_RecordsTaggedMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	root, closer := NewHandler(registry)
+	handler := root.WithTags(map[string]string{TagStepType: "yum_upgrade"})
+
+	succeeded := handler.WithTags(map[string]string{TagOutcome: OutcomeSucceeded})
+	succeeded.Counter(StepsExecuted).Inc(2)
+	succeeded.Timer(StepDuration).Record(3 * time.Second)
+	handler.WithTags(map[string]string{TagOutcome: OutcomeFailed}).Counter(StepsExecuted).Inc(1)
+	handler.Gauge("temporal_worker_task_slots_available").Update(5)
+	if err := closer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	labels := map[string]string{TagStepType: "yum_upgrade", TagOutcome: OutcomeSucceeded}
+	if m := find(t, registry, StepsExecuted+"_total", labels); m == nil || m.GetCounter().GetValue() != 2 {
+		t.Errorf("Expected 2 succeeded steps, got %v", m)
+	}
+	if m := find(t, registry, StepDuration+"_seconds", labels); m == nil || m.GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("Expected a step duration histogram, got %v", m)
+	}
+	labels[TagOutcome] = OutcomeFailed
+	if m := find(t, registry, StepsExecuted+"_total", labels); m == nil || m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected 1 failed step, got %v", m)
+	}
+	if m := find(t, registry, "temporal_worker_task_slots_available", map[string]string{TagStepType: "yum_upgrade"}); m == nil || m.GetGauge().GetValue() != 5 {
+		t.Errorf("Expected the gauge value, got %v", m)
+	}
+}
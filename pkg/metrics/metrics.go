@@ -0,0 +1,116 @@
+// Package metrics exposes the metrics of the Kitsune workers to Prometheus,
+// through the Temporal SDK's tally metrics handler and tally's Prometheus
+// reporter.
+//
+// Kitsune's own metrics are emitted through the Temporal metrics handler, like
+// the Temporal SDK metrics, so they are skipped when workflows replay: use
+// activity.GetMetricsHandler in activities and workflow.GetMetricsHandler in
+// workflows with the names below.
+package metrics
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uber-go/tally/v4"
+	tallyprom "github.com/uber-go/tally/v4/prometheus"
+	"go.temporal.io/sdk/client"
+	sdktally "go.temporal.io/sdk/contrib/tally"
+)
+
+// Kitsune metric names. Counters are exported with a _total suffix and timers
+// as histograms in seconds with a _seconds suffix.
+const (
+	// StepsExecuted counts the steps executed by local workers, by step_type and outcome
+	StepsExecuted = "kitsune_steps_executed"
+	// StepDuration is the time taken by step handlers, by step_type and outcome
+	StepDuration = "kitsune_step_duration"
+	// StepRollbacks counts the steps rolled back by local workers, by step_type and outcome
+	StepRollbacks = "kitsune_step_rollbacks"
+	// ValidationFailures counts the plans rejected by step validation
+	ValidationFailures = "kitsune_validation_failures"
+	// Servers counts the servers orchestrated, by strategy and outcome
+	Servers = "kitsune_servers"
+	// ServerRollbacks counts the servers the orchestrator rolled back, by strategy and outcome
+	ServerRollbacks = "kitsune_server_rollbacks"
+)
+
+// Tags of the Kitsune metrics
+const (
+	TagStepType = "step_type"
+	TagOutcome  = "outcome"
+	TagStrategy = "strategy"
+)
+
+// Outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomePatched   = "patched"
+	OutcomeCancelled = "cancelled"
+	OutcomeSkipped   = "skipped"
+)
+
+// Outcome is OutcomeSucceeded, or OutcomeFailed when err is set
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailed
+	}
+	return OutcomeSucceeded
+}
+
+// timerBuckets cover both Temporal latencies of a few milliseconds and steps
+// that take hours
+var timerBuckets = prometheus.ExponentialBuckets(0.005, 4, 12)
+
+// reportInterval is how often metrics are copied to the Prometheus registry
+const reportInterval = time.Second
+
+// NewHandler returns a Temporal metrics handler that records to registry.
+// Metrics are named as Temporal recommends for Prometheus: counters get a
+// _total suffix and timers are histograms in seconds with a _seconds suffix.
+// They reach the registry every second; closing the returned io.Closer reports
+// the final values.
+func NewHandler(registry *prometheus.Registry) (client.MetricsHandler, io.Closer) {
+	reporter := tallyprom.NewReporter(tallyprom.Options{
+		Registerer:              registry,
+		DefaultTimerType:        tallyprom.HistogramTimerType,
+		DefaultHistogramBuckets: timerBuckets,
+		OnRegisterError: func(err error) {
+			log.Printf("Failed to register metric: %v", err)
+		},
+	})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter:  reporter,
+		Separator:       tallyprom.DefaultSeparator,
+		SanitizeOptions: &sdktally.PrometheusSanitizeOptions,
+	}, reportInterval)
+	return sdktally.NewMetricsHandler(sdktally.NewPrometheusNamingScope(scope)), closer
+}
+
+// NewRegistry returns a registry with the Go runtime and process metrics
+func NewRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// Serve serves the metrics of gatherer on /metrics at addr
+func Serve(addr string, gatherer prometheus.Gatherer) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
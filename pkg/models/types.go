@@ -1,9 +1,25 @@
 package models
 
+import "time"
+
 // WorkflowInput is the input for ServerExecutionWorkflow
 type WorkflowInput struct {
 	ServerID string           `json:"serverID"`
 	Steps    []StepDefinition `json:"steps"`
+	// Finally steps always run after Steps, see ExecutionRequest.Finally
+	Finally []StepDefinition `json:"finally,omitempty"`
+	// Audit identifies the plan and requester in the audit log of the local worker
+	Audit AuditInfo `json:"audit"`
+	// Vars and Labels are available to step conditions and parameter templates
+	// as vars.* and server.labels.*
+	Vars   map[string]interface{} `json:"vars,omitempty"`
+	Labels map[string]string      `json:"labels,omitempty"`
+}
+
+// AuditInfo identifies the plan a step runs for and who requested it
+type AuditInfo struct {
+	PlanID    string `json:"planId,omitempty"` // orchestration workflow ID
+	Requester string `json:"requester,omitempty"`
 }
 
 // StepDefinition represents a single step to execute
@@ -13,6 +29,28 @@ type StepDefinition struct {
 	Params            map[string]interface{} `json:"params,omitempty"`
 	Required          bool                   `json:"required"`
 	ContinueOnFailure bool                   `json:"continueOnFailure"`
+	// DependsOn names the steps that must succeed before this one starts. When
+	// any step sets it, steps run as a graph and independent steps run concurrently.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// When is a condition the step only runs if true, e.g.
+	// "steps.upgrade.outputs.changed == true". See package expr.
+	When string `json:"when,omitempty"`
+	// Timeout bounds each attempt of the step, as a duration such as "30m" (default 5m)
+	Timeout string `json:"timeout,omitempty"`
+	// HeartbeatTimeout fails an attempt whose handler stops heartbeating, e.g. "30s"
+	HeartbeatTimeout string `json:"heartbeatTimeout,omitempty"`
+	// Retry overrides the default retry policy (3 attempts) of the step
+	Retry *StepRetryPolicy `json:"retry,omitempty"`
+}
+
+// StepRetryPolicy controls how a failed step attempt is retried. The timeout,
+// heartbeat timeout and retry policy of a step also apply to its rollback.
+type StepRetryPolicy struct {
+	MaxAttempts            int      `json:"maxAttempts,omitempty"`            // total attempts, 1 never retries (default 3)
+	InitialInterval        string   `json:"initialInterval,omitempty"`        // delay before the first retry (default 1s)
+	BackoffCoefficient     float64  `json:"backoffCoefficient,omitempty"`     // growth of the delay per retry (default 2)
+	MaxInterval            string   `json:"maxInterval,omitempty"`            // cap on the delay (default 1m)
+	NonRetryableErrorTypes []string `json:"nonRetryableErrorTypes,omitempty"` // error types that fail the step at once
 }
 
 // ExecutionResult is the result of executing steps on one server
@@ -21,6 +59,15 @@ type ExecutionResult struct {
 	Success       bool         `json:"success"`
 	Error         string       `json:"error,omitempty"`
 	StepsExecuted []StepResult `json:"stepsExecuted"`
+	// Cancelled is set when the orchestrator stopped the server before it finished
+	Cancelled bool `json:"cancelled,omitempty"`
+	// Skipped is set when the server never ran because a server it depends on did not succeed
+	Skipped bool `json:"skipped,omitempty"`
+	// Rollback is set when the server compensated its executed steps after a
+	// required step failed or it was cancelled
+	Rollback *RollbackResult `json:"rollback,omitempty"`
+	// Finally holds the results of the finally steps, which do not affect Success
+	Finally []StepResult `json:"finally,omitempty"`
 }
 
 // StepResult is the result of a single step
@@ -28,15 +75,79 @@ type StepResult struct {
 	Name    string `json:"name"`
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+	// Skipped is set when the step did not run, either because its When condition
+	// was false or, with the reason in Error, because a dependency did not succeed
+	Skipped bool `json:"skipped,omitempty"`
+	// Metadata is the ExecutionMetadata returned by the step handler, needed to
+	// roll the step back. Failed steps carry the outputs their handler attached
+	// to the error, such as the exit code, stdout and stderr of a script. The
+	// orchestration result leaves out stdout and stderr.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// OutputDigest is the digest of the full metadata, set by the orchestrator
+	// when it drops large outputs such as stdout and stderr from Metadata
+	OutputDigest string `json:"outputDigest,omitempty"`
+	// Params are the parameters the step ran with, set when they were resolved
+	// from templates, so the step is rolled back with the same values
+	Params map[string]interface{} `json:"params,omitempty"`
+	// StartedAt and FinishedAt are unset for steps that did not run
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RollbackResult is the outcome of compensating the executed steps on one server
+type RollbackResult struct {
+	ServerID    string               `json:"serverId"`
+	Success     bool                 `json:"success"`
+	Reason      string               `json:"reason,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	Steps       []StepRollbackResult `json:"steps"`
+	StartedAt   time.Time            `json:"startedAt"`
+	CompletedAt time.Time            `json:"completedAt"`
+}
+
+// StepRollbackResult is the outcome of rolling back a single step
+type StepRollbackResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
 }
 
 // RolloutStrategy defines how to execute across servers
 type RolloutStrategy struct {
-	Type              string `json:"type"` // Rolling, Parallel, Sequential, Canary
-	BatchSize         int    `json:"batchSize,omitempty"`
-	BatchDelaySeconds int    `json:"batchDelaySeconds,omitempty"`
-	MaxFailures       int    `json:"maxFailures,omitempty"`
-	CanaryPercentage  int    `json:"canaryPercentage,omitempty"`
+	Type              string          `json:"type"` // Rolling, Parallel, Sequential, Canary, Topology
+	BatchSize         int             `json:"batchSize,omitempty"`
+	BatchDelaySeconds int             `json:"batchDelaySeconds,omitempty"`
+	MaxFailures       int             `json:"maxFailures,omitempty"`
+	MaxFailurePercent int             `json:"maxFailurePercent,omitempty"` // abort once more than this % of all servers failed
+	FailureWindow     *FailureWindow  `json:"failureWindow,omitempty"`
+	MaxConcurrency    int             `json:"maxConcurrency,omitempty"` // Parallel: max servers in flight, 0 for unbounded
+	CanaryPercentage  int             `json:"canaryPercentage,omitempty"`
+	CanaryBakeSeconds int             `json:"canaryBakeSeconds,omitempty"`
+	CanaryFollowUp    string          `json:"canaryFollowUp,omitempty"` // Rolling (default) or Parallel
+	Approval          *ApprovalPolicy `json:"approval,omitempty"`
+	Topology          *TopologyPolicy `json:"topology,omitempty"`
+}
+
+// TopologyPolicy batches a rollout by server groups built from ExecutionRequest.ServerLabels
+type TopologyPolicy struct {
+	GroupBy        []string `json:"groupBy"`                  // label keys that identify a group, e.g. ["zone", "rack"]
+	MaxUnavailable int      `json:"maxUnavailable,omitempty"` // servers per group in each batch (default 1)
+	SerialBy       string   `json:"serialBy,omitempty"`       // label key whose groups are finished one at a time, e.g. "zone"
+}
+
+// FailureWindow aborts a rollout when more than MaxFailurePercent of the last
+// Size completed servers failed
+type FailureWindow struct {
+	Size              int `json:"size"`
+	MaxFailurePercent int `json:"maxFailurePercent"`
+}
+
+// ApprovalPolicy makes a rolling rollout wait for a human approval signal after batches
+type ApprovalPolicy struct {
+	AfterBatches   []int  `json:"afterBatches,omitempty"`   // batch numbers (1-based) followed by a gate
+	EveryBatch     bool   `json:"everyBatch,omitempty"`     // gate after every batch
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"` // 0 waits indefinitely
+	OnTimeout      string `json:"onTimeout,omitempty"`      // abort (default) or proceed
 }
 
 // ExecutionRequest is input for orchestration workflow
@@ -44,12 +155,144 @@ type ExecutionRequest struct {
 	Servers         []string         `json:"servers"`
 	Steps           []StepDefinition `json:"steps"`
 	RolloutStrategy RolloutStrategy  `json:"rolloutStrategy"`
+	// Requester is recorded in the audit log as the person or system that
+	// requested the orchestration
+	Requester string `json:"requester,omitempty"`
+	// Finally steps run in order on every server after Steps, whether they
+	// succeeded, failed, were rolled back or were cancelled. Use them for
+	// cleanup such as removing a maintenance flag.
+	Finally []StepDefinition `json:"finally,omitempty"`
+	// ServerLabels holds labels such as zone or rack for each server, used by the
+	// Topology strategy and by label selectors in DependsOn
+	ServerLabels map[string]map[string]string `json:"serverLabels,omitempty"`
+	// DependsOn orders the rollout: each key must wait for every server it lists
+	// to succeed. Keys and values are server IDs or label selectors ("role=db").
+	DependsOn map[string][]string `json:"dependsOn,omitempty"`
+	// Vars are plan variables available to step conditions and parameter templates as vars.*
+	Vars map[string]interface{} `json:"vars,omitempty"`
+	// WorkflowIDReusePolicy applies to the child workflows started for each server:
+	// AllowDuplicateFailedOnly (default), AllowDuplicate or RejectDuplicate
+	WorkflowIDReusePolicy string `json:"workflowIdReusePolicy,omitempty"`
+	// RetryOf re-runs a previous orchestration on the servers that failed or never
+	// ran, reusing its steps and strategy; servers and steps are then ignored
+	RetryOf *OrchestrationRef `json:"retryOf,omitempty"`
+}
+
+// RequestMemoKey is the memo field holding the effective ExecutionRequest of an orchestration
+const RequestMemoKey = "request"
+
+// OrchestrationRef identifies an orchestration run
+type OrchestrationRef struct {
+	WorkflowID string `json:"workflowId"`
+	RunID      string `json:"runId,omitempty"` // latest run if empty
+}
+
+// OrchestrationRecord is the effective request and the result of a finished orchestration
+type OrchestrationRecord struct {
+	Ref     OrchestrationRef    `json:"ref"`
+	Request ExecutionRequest    `json:"request"`
+	Result  OrchestrationResult `json:"result"`
+}
+
+// AbortRequest is the payload of the abort signal sent to a running orchestration
+type AbortRequest struct {
+	Reason   string `json:"reason"`
+	Identity string `json:"identity,omitempty"`
+	Rollback bool   `json:"rollback"` // roll back servers that already completed
+}
+
+// ApprovalRequest is the payload of the approve signal sent to a gated orchestration
+type ApprovalRequest struct {
+	Identity string `json:"identity"`
+	Comment  string `json:"comment,omitempty"`
+	Batch    int    `json:"batch,omitempty"` // optional, must match the gate being approved
+}
+
+// ApprovalRecord records how an approval gate was passed
+type ApprovalRecord struct {
+	Batch      int       `json:"batch"`
+	Approved   bool      `json:"approved"`
+	ApprovedBy string    `json:"approvedBy,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	TimedOut   bool      `json:"timedOut,omitempty"`
+	Time       time.Time `json:"time"`
 }
 
 // OrchestrationResult is the output for orchestration workflow
 type OrchestrationResult struct {
-	Success        bool              `json:"success"`
-	ServersPatched int               `json:"serversPatched"`
-	ServersFailed  int               `json:"serversFailed"`
-	Results        []ExecutionResult `json:"results"`
+	Success        bool `json:"success"`
+	ServersPatched int  `json:"serversPatched"`
+	ServersFailed  int  `json:"serversFailed"`
+	// ServersCancelled counts servers stopped once a failure threshold was exceeded,
+	// ServersSkipped servers not run because a dependency did not succeed
+	ServersCancelled int               `json:"serversCancelled,omitempty"`
+	ServersSkipped   int               `json:"serversSkipped,omitempty"`
+	Results          []ExecutionResult `json:"results"`
+	Error            string            `json:"error,omitempty"`
+	Aborted          bool              `json:"aborted,omitempty"`
+	AbortReason      string            `json:"abortReason,omitempty"`
+	AbortedBy        string            `json:"abortedBy,omitempty"`
+	Approvals        []ApprovalRecord  `json:"approvals,omitempty"`
+	RetryOf          *OrchestrationRef `json:"retryOf,omitempty"`
+	// Rollbacks lists every server rollback, whether triggered by the orchestrator
+	// or performed by the server itself after a required step failed
+	Rollbacks         []RollbackResult `json:"rollbacks,omitempty"`
+	ServersRolledBack int              `json:"serversRolledBack"`
+	RollbackFailures  int              `json:"rollbackFailures"`
+}
+
+// Statuses reported for servers and steps by the progress queries
+const (
+	StatusPending        = "pending"
+	StatusRunning        = "running"
+	StatusSucceeded      = "succeeded"
+	StatusFailed         = "failed"
+	StatusRolledBack     = "rolled_back"
+	StatusRollbackFailed = "rollback_failed"
+	StatusCancelled      = "cancelled"
+	StatusSkipped        = "skipped"
+)
+
+// Phases reported by the orchestration progress query
+const (
+	PhaseRunning          = "running"
+	PhaseCanary           = "canary"
+	PhaseBaking           = "baking"
+	PhaseAwaitingApproval = "awaiting_approval"
+	PhaseRollingBack      = "rolling_back"
+	PhaseCompleted        = "completed"
+	PhaseFailed           = "failed"
+	PhaseAborted          = "aborted"
+)
+
+// OrchestrationProgress is the live state returned by the orchestration progress query
+type OrchestrationProgress struct {
+	Phase        string           `json:"phase"`
+	Strategy     string           `json:"strategy"`
+	Paused       bool             `json:"paused"`
+	CurrentBatch int              `json:"currentBatch,omitempty"`
+	TotalBatches int              `json:"totalBatches,omitempty"`
+	Servers      []ServerProgress `json:"servers"`
+}
+
+// ServerProgress is the status of one server within an orchestration
+type ServerProgress struct {
+	ServerID   string `json:"serverId"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	WorkflowID string `json:"workflowId,omitempty"` // ID of the server's execution child workflow
+}
+
+// ExecutionProgress is the live state returned by the server execution progress query
+type ExecutionProgress struct {
+	ServerID string         `json:"serverId"`
+	Status   string         `json:"status"`
+	Steps    []StepProgress `json:"steps"`
+}
+
+// StepProgress is the status of one step on a server
+type StepProgress struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
@@ -0,0 +1,79 @@
+// Package notify delivers orchestration lifecycle events, such as a server
+// failing or a rollback starting, to external systems.
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// Event types
+const (
+	EventOrchestrationStarted   = "orchestration.started"
+	EventBatchCompleted         = "batch.completed"
+	EventServerFailed           = "server.failed"
+	EventRollbackStarted        = "rollback.started"
+	EventRollbackFailed         = "rollback.failed"
+	EventOrchestrationCompleted = "orchestration.completed"
+)
+
+// Event is a lifecycle event of an orchestration
+type Event struct {
+	// ID is unique per orchestration run, so receivers can drop redelivered events
+	ID              string    `json:"id"`
+	Type            string    `json:"type"`
+	OrchestrationID string    `json:"orchestrationId"`
+	RunID           string    `json:"runId"`
+	Time            time.Time `json:"time"`
+
+	ServerID     string   `json:"serverId,omitempty"`
+	Servers      []string `json:"servers,omitempty"` // orchestration.started, batch.completed
+	Strategy     string   `json:"strategy,omitempty"`
+	Batch        int      `json:"batch,omitempty"`
+	TotalBatches int      `json:"totalBatches,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	Error        string   `json:"error,omitempty"`
+
+	// Result is set on orchestration.completed
+	Result *Summary `json:"result,omitempty"`
+}
+
+// Summary is the outcome of an orchestration without its per-server results,
+// which receivers can get from the workflow itself
+type Summary struct {
+	Success           bool   `json:"success"`
+	ServersPatched    int    `json:"serversPatched"`
+	ServersFailed     int    `json:"serversFailed"`
+	ServersCancelled  int    `json:"serversCancelled,omitempty"`
+	ServersSkipped    int    `json:"serversSkipped,omitempty"`
+	ServersRolledBack int    `json:"serversRolledBack"`
+	RollbackFailures  int    `json:"rollbackFailures"`
+	Error             string `json:"error,omitempty"`
+	Aborted           bool   `json:"aborted,omitempty"`
+	AbortReason       string `json:"abortReason,omitempty"`
+	AbortedBy         string `json:"abortedBy,omitempty"`
+}
+
+// Summarize returns the summary of an orchestration result
+func Summarize(result *models.OrchestrationResult) *Summary {
+	return &Summary{
+		Success:           result.Success,
+		ServersPatched:    result.ServersPatched,
+		ServersFailed:     result.ServersFailed,
+		ServersCancelled:  result.ServersCancelled,
+		ServersSkipped:    result.ServersSkipped,
+		ServersRolledBack: result.ServersRolledBack,
+		RollbackFailures:  result.RollbackFailures,
+		Error:             result.Error,
+		Aborted:           result.Aborted,
+		AbortReason:       result.AbortReason,
+		AbortedBy:         result.AbortedBy,
+	}
+}
+
+// Sink delivers events to one destination
+type Sink interface {
+	Send(ctx context.Context, event Event) error
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Headers set on every webhook request
+const (
+	HeaderEvent     = "X-Kitsune-Event"
+	HeaderSignature = "X-Kitsune-Signature"
+)
+
+// WebhookSink posts events as JSON to an HTTP endpoint. When a secret is set,
+// the body is signed with HMAC-SHA256 and the signature sent in the
+// X-Kitsune-Signature header as "sha256=<hex>", so receivers can verify it
+// with Verify.
+type WebhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send posts event and fails unless the endpoint answers with a 2xx status
+func (s *WebhookSink) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	if len(s.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(s.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event to %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{URL: s.url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// StatusError is returned when a webhook endpoint rejects an event
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook %s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Permanent reports whether sending the event again cannot succeed: the
+// endpoint rejected the request itself rather than failing or throttling
+func (e *StatusError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// Sign returns the X-Kitsune-Signature header value for body
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the X-Kitsune-Signature of body
+func Verify(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
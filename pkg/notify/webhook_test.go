@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// receiver is a local webhook endpoint that verifies signatures
+type receiver struct {
+	secret []byte
+	status int
+	events []Event
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if !Verify(r.secret, body, req.Header.Get(HeaderSignature)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get(HeaderEvent) != event.Type {
+		http.Error(w, "event header mismatch", http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, event)
+
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func TestWebhookSink_SendsSignedEvent(t *testing.T) {
+	r := &receiver{secret: []byte("s3cret")}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	event := Event{ID: "run-1", Type: EventServerFailed, OrchestrationID: "patch-42", ServerID: "web-1", Error: "step failed"}
+	if err := NewWebhookSink(server.URL, "s3cret").Send(context.Background(), event); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if len(r.events) != 1 || r.events[0].ServerID != "web-1" || r.events[0].Type != EventServerFailed {
+		t.Errorf("Expected the event to be received, got %+v", r.events)
+	}
+}
+
+func TestWebhookSink_WrongSecretIsRejected(t *testing.T) {
+	r := &receiver{secret: []byte("s3cret")}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	err := NewWebhookSink(server.URL, "other").Send(context.Background(), Event{Type: EventOrchestrationStarted})
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || !statusErr.Permanent() {
+		t.Errorf("Expected a permanent 401 error, got: %v", err)
+	}
+}
+
+func TestWebhookSink_ServerErrorIsNotPermanent(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusTooManyRequests} {
+		r := &receiver{secret: []byte("s3cret"), status: status}
+		server := httptest.NewServer(r)
+
+		err := NewWebhookSink(server.URL, "s3cret").Send(context.Background(), Event{Type: EventOrchestrationStarted})
+		server.Close()
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != status || statusErr.Permanent() {
+			t.Errorf("Expected a retryable %d error, got: %v", status, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"type":"orchestration.started"}`)
+
+	signature := Sign(secret, body)
+	if !Verify(secret, body, signature) {
+		t.Error("Expected signature to verify")
+	}
+	if Verify(secret, []byte(`{"type":"orchestration.completed"}`), signature) {
+		t.Error("Expected signature of a different body not to verify")
+	}
+}
@@ -0,0 +1,109 @@
+// Package tracing sets up OpenTelemetry tracing for the Kitsune workers. With
+// tracing enabled, an orchestration is a single trace: the OrchestrationWorkflow
+// span is the parent of a span per ServerExecutionWorkflow, which is the parent
+// of a span per ExecuteStep activity. Step handlers add child spans for the work
+// they do, such as running a subprocess, with Start.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	temporalotel "go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/interceptor"
+)
+
+const tracerName = "github.com/melslow/kitsune"
+
+// Setup installs a global tracer provider exporting spans to the destination
+// configured in the environment, and returns the Temporal interceptor that
+// traces workflows and activities together with a function flushing the spans
+// on shutdown.
+//
+// Spans are exported over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard
+// OTEL_EXPORTER_OTLP_* variables, or appended as JSON to the file at
+// KITSUNE_TRACES_FILE. Without either, tracing is disabled and the interceptor
+// is nil.
+func Setup(ctx context.Context, service string, attrs ...attribute.KeyValue) (interceptor.Interceptor, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch {
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+		otlp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		exporter = otlp
+	case os.Getenv("KITSUNE_TRACES_FILE") != "":
+		var err error
+		file, err = os.OpenFile(os.Getenv("KITSUNE_TRACES_FILE"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to open traces file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, noop, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+	default:
+		return nil, noop, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		append([]attribute.KeyValue{attribute.String("service.name", service)}, attrs...)...,
+	))
+	if err != nil {
+		return nil, noop, fmt.Errorf("failed to describe service: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	tracingInterceptor, err := temporalotel.NewTracingInterceptor(temporalotel.TracerOptions{})
+	if err != nil {
+		provider.Shutdown(ctx)
+		return nil, noop, fmt.Errorf("failed to create tracing interceptor: %w", err)
+	}
+
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}
+	return tracingInterceptor, shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, which in an activity is
+// the span of the activity. The span must be ended, e.g. with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it as failed with err if it is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup_ExportsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	t.Setenv("KITSUNE_TRACES_FILE", path)
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	tracingInterceptor, shutdown, err := Setup(context.Background(), "kitsune-test")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if tracingInterceptor == nil {
+		t.Fatal("Expected a tracing interceptor")
+	}
+
+	_, span := Start(context.Background(), "yum upgrade")
+	End(span, errors.New("mirror unreachable"))
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Name":"yum upgrade"`, "mirror unreachable", "kitsune-test"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in the exported spans, got %s", want, data)
+		}
+	}
+}
+
+func TestSetup_DisabledWithoutExporter(t *testing.T) {
+	t.Setenv("KITSUNE_TRACES_FILE", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	tracingInterceptor, shutdown, err := Setup(context.Background(), "kitsune-test")
+	if err != nil || tracingInterceptor != nil {
+		t.Errorf("Expected tracing to be disabled, got %v, %v", tracingInterceptor, err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
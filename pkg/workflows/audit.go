@@ -0,0 +1,111 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// auditBatchSize bounds the records sent to a single RecordAudit activity
+const auditBatchSize = 500
+
+// auditInfo identifies this orchestration in the audit logs of the local workers
+func (s *orchestrationState) auditInfo(ctx workflow.Context) models.AuditInfo {
+	return models.AuditInfo{
+		PlanID:    workflow.GetInfo(ctx).WorkflowExecution.ID,
+		Requester: s.requester,
+	}
+}
+
+// recordAudit writes every step executed or rolled back on every server to the
+// orchestrator's audit log through the RecordAudit activity, complementing the
+// logs the local workers keep. A failure to record is logged and otherwise
+// ignored, as the steps have already run.
+func (s *orchestrationState) recordAudit(ctx workflow.Context, req models.ExecutionRequest, result *models.OrchestrationResult) {
+	logger := workflow.GetLogger(ctx)
+	records := auditRecords(s.auditInfo(ctx), workflow.Now(ctx), req, result)
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+	for start := 0; start < len(records); start += auditBatchSize {
+		end := min(start+auditBatchSize, len(records))
+		if err := workflow.ExecuteActivity(ctx, "RecordAudit", records[start:end]).Get(ctx, nil); err != nil {
+			logger.Warn("Failed to record audit log", "records", end-start, "error", err)
+		}
+	}
+}
+
+// auditRecords builds one record per step in the server results and rollbacks
+func auditRecords(info models.AuditInfo, now time.Time, req models.ExecutionRequest, result *models.OrchestrationResult) []audit.Record {
+	definitions := make(map[string]models.StepDefinition, len(req.Steps)+len(req.Finally))
+	for _, step := range append(append([]models.StepDefinition(nil), req.Steps...), req.Finally...) {
+		definitions[step.Name] = step
+	}
+
+	newRecord := func(action string, serverID string, name string) audit.Record {
+		step := definitions[name]
+		return audit.Record{
+			Time:       now,
+			RecordedBy: "orchestrator",
+			Phase:      audit.PhaseReported,
+			Action:     action,
+			PlanID:     info.PlanID,
+			Requester:  info.Requester,
+			ServerID:   serverID,
+			Step:       name,
+			StepType:   step.Type,
+			ParamsHash: audit.Digest(step.Params),
+		}
+	}
+
+	var records []audit.Record
+	for _, server := range result.Results {
+		for _, stepResult := range append(append([]models.StepResult(nil), server.StepsExecuted...), server.Finally...) {
+			record := newRecord(audit.ActionExecute, server.ServerID, stepResult.Name)
+			if stepResult.Params != nil {
+				record.ParamsHash = audit.Digest(stepResult.Params)
+			}
+			record.StartedAt = stepResult.StartedAt
+			record.FinishedAt = stepResult.FinishedAt
+			record.Error = stepResult.Error
+			record.OutputDigest = stepResult.OutputDigest
+			if record.OutputDigest == "" {
+				record.OutputDigest = audit.Digest(stepResult.Metadata)
+			}
+			switch {
+			case stepResult.Success:
+				record.Result = audit.ResultSucceeded
+			case stepResult.Skipped:
+				record.Result = audit.ResultSkipped
+			default:
+				record.Result = audit.ResultFailed
+			}
+			records = append(records, record)
+		}
+	}
+
+	for _, rollback := range result.Rollbacks {
+		for _, stepRollback := range rollback.Steps {
+			record := newRecord(audit.ActionRollback, rollback.ServerID, stepRollback.Name)
+			record.Result = audit.ResultSucceeded
+			if !stepRollback.Success {
+				record.Result = audit.ResultFailed
+				record.Error = stepRollback.Error
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
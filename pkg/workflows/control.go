@@ -0,0 +1,256 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// Signals accepted by OrchestrationWorkflow
+const (
+	SignalPause   = "pause"
+	SignalResume  = "resume"
+	SignalAbort   = "abort"   // payload: models.AbortRequest
+	SignalApprove = "approve" // payload: models.ApprovalRequest
+)
+
+// orchestrationState holds the mutable state of a running orchestration that is
+// shared between the rollout strategies and the signal handlers
+type orchestrationState struct {
+	paused      bool
+	abort       *models.AbortRequest
+	rolledBack  map[string]bool
+	rollbacks   []models.RollbackResult
+	reusePolicy enumspb.WorkflowIdReusePolicy
+
+	// ready once an abort has been requested, waking strategies that wait on
+	// running servers
+	aborted    workflow.Future
+	setAborted workflow.Settable
+
+	// approval gate currently waited on (0 if none) and the approval received for it
+	gate      int
+	approval  *models.ApprovalRequest
+	approvals []models.ApprovalRecord
+
+	progress    models.OrchestrationProgress
+	serverIndex map[string]int
+
+	// lifecycle events sent so far and their deliveries
+	eventCount    int
+	notifications []workflow.Future
+
+	// requester of the orchestration, recorded in the audit log
+	requester string
+}
+
+func newOrchestrationState(req models.ExecutionRequest) *orchestrationState {
+	s := &orchestrationState{
+		rolledBack:  make(map[string]bool),
+		serverIndex: make(map[string]int),
+		progress: models.OrchestrationProgress{
+			Phase:    models.PhaseRunning,
+			Strategy: req.RolloutStrategy.Type,
+			Servers:  make([]models.ServerProgress, 0, len(req.Servers)),
+		},
+		requester: req.Requester,
+	}
+
+	for i, serverID := range req.Servers {
+		s.serverIndex[serverID] = i
+		s.progress.Servers = append(s.progress.Servers, models.ServerProgress{
+			ServerID: serverID,
+			Status:   models.StatusPending,
+		})
+	}
+
+	return s
+}
+
+// listenForSignals starts a coroutine that applies pause, resume and abort
+// signals to the state for the lifetime of the workflow
+func (s *orchestrationState) listenForSignals(ctx workflow.Context) {
+	logger := workflow.GetLogger(ctx)
+	s.aborted, s.setAborted = workflow.NewFuture(ctx)
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalPause), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		logger.Info("Orchestration paused")
+		s.paused = true
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalResume), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		logger.Info("Orchestration resumed")
+		s.paused = false
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalAbort), func(c workflow.ReceiveChannel, more bool) {
+		var abort models.AbortRequest
+		c.Receive(ctx, &abort)
+		if s.abort != nil {
+			logger.Warn("Orchestration already aborted, ignoring abort signal", "identity", abort.Identity)
+			return
+		}
+		logger.Warn("Orchestration abort requested", "reason", abort.Reason, "identity", abort.Identity, "rollback", abort.Rollback)
+		s.requestAbort(&abort)
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalApprove), func(c workflow.ReceiveChannel, more bool) {
+		var approval models.ApprovalRequest
+		c.Receive(ctx, &approval)
+		if s.gate == 0 || (approval.Batch != 0 && approval.Batch != s.gate) {
+			logger.Warn("Ignoring approval, no matching gate is waiting", "identity", approval.Identity, "batch", approval.Batch, "gate", s.gate)
+			return
+		}
+		logger.Info("Approval received", "identity", approval.Identity, "batch", s.gate)
+		s.approval = &approval
+	})
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			selector.Select(ctx)
+		}
+	})
+}
+
+// checkpoint is called by the rollout strategies between servers and batches.
+// It blocks while the orchestration is paused and returns an error once an
+// abort has been requested.
+func (s *orchestrationState) checkpoint(ctx workflow.Context) error {
+	if s.paused && s.abort == nil {
+		workflow.GetLogger(ctx).Info("Orchestration paused, waiting for resume or abort")
+	}
+
+	if err := workflow.Await(ctx, func() bool { return !s.paused || s.abort != nil }); err != nil {
+		return err
+	}
+
+	return s.abortError()
+}
+
+// sleep waits for the given duration, returning early with an error if the
+// orchestration is aborted in the meantime
+func (s *orchestrationState) sleep(ctx workflow.Context, d time.Duration) error {
+	if _, err := workflow.AwaitWithTimeout(ctx, d, func() bool { return s.abort != nil }); err != nil {
+		return err
+	}
+	return s.abortError()
+}
+
+// approvalRequired reports whether the policy gates the rollout after the given batch
+func approvalRequired(policy *models.ApprovalPolicy, batch int) bool {
+	if policy == nil {
+		return false
+	}
+	if policy.EveryBatch {
+		return true
+	}
+	for _, b := range policy.AfterBatches {
+		if b == batch {
+			return true
+		}
+	}
+	return false
+}
+
+// validateApprovalPolicy checks that the strategy rolls out in batches an
+// approval policy can gate, and that its timeout action is known
+func validateApprovalPolicy(s models.RolloutStrategy) error {
+	policy := s.Approval
+	if policy == nil {
+		return nil
+	}
+
+	batched := s.Type == "Rolling" || s.Type == "Topology" ||
+		(s.Type == "Canary" && (s.CanaryFollowUp == "" || s.CanaryFollowUp == "Rolling"))
+	if !batched {
+		return fmt.Errorf("approval requires a strategy with batches, not %q", s.Type)
+	}
+
+	switch policy.OnTimeout {
+	case "", "abort", "proceed":
+		return nil
+	default:
+		return fmt.Errorf("invalid approval onTimeout: %s (must be abort or proceed)", policy.OnTimeout)
+	}
+}
+
+// awaitApproval blocks after a batch until an approval signal for it arrives.
+// When the policy has a timeout, the gate either proceeds or aborts the
+// orchestration once it expires.
+func (s *orchestrationState) awaitApproval(ctx workflow.Context, policy *models.ApprovalPolicy, batch int) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Waiting for approval", "batch", batch, "timeoutSeconds", policy.TimeoutSeconds)
+
+	phase := s.progress.Phase
+	s.setPhase(models.PhaseAwaitingApproval)
+	s.gate = batch
+	s.approval = nil
+	defer func() {
+		s.gate = 0
+		s.approval = nil
+		s.setPhase(phase)
+	}()
+
+	approved := func() bool { return s.approval != nil || s.abort != nil }
+	if policy.TimeoutSeconds > 0 {
+		ok, err := workflow.AwaitWithTimeout(ctx, time.Duration(policy.TimeoutSeconds)*time.Second, approved)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			record := models.ApprovalRecord{
+				Batch:    batch,
+				TimedOut: true,
+				Time:     workflow.Now(ctx),
+			}
+
+			if policy.OnTimeout == "proceed" {
+				logger.Warn("Approval timed out, proceeding", "batch", batch)
+				record.Approved = true
+				s.approvals = append(s.approvals, record)
+				return nil
+			}
+
+			logger.Error("Approval timed out, aborting", "batch", batch)
+			s.approvals = append(s.approvals, record)
+			s.requestAbort(&models.AbortRequest{
+				Reason: fmt.Sprintf("approval for batch %d timed out after %ds", batch, policy.TimeoutSeconds),
+			})
+			return s.abortError()
+		}
+	} else if err := workflow.Await(ctx, approved); err != nil {
+		return err
+	}
+
+	if err := s.abortError(); err != nil {
+		return err
+	}
+
+	s.approvals = append(s.approvals, models.ApprovalRecord{
+		Batch:      batch,
+		Approved:   true,
+		ApprovedBy: s.approval.Identity,
+		Comment:    s.approval.Comment,
+		Time:       workflow.Now(ctx),
+	})
+	logger.Info("Batch approved", "batch", batch, "approvedBy", s.approval.Identity)
+	return nil
+}
+
+// requestAbort records the abort and readies s.aborted
+func (s *orchestrationState) requestAbort(abort *models.AbortRequest) {
+	s.abort = abort
+	s.setAborted.Set(nil, nil)
+}
+
+// abortError returns a non-nil error if an abort has been requested
+func (s *orchestrationState) abortError() error {
+	if s.abort == nil {
+		return nil
+	}
+	return fmt.Errorf("orchestration aborted: %s", s.abort.Reason)
+}
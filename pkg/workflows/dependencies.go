@@ -0,0 +1,204 @@
+package workflows
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// dependencyExecution rolls out the servers in waves that respect
+// req.DependsOn. Each wave runs with the configured rollout strategy once the
+// previous waves are done, with budget counting failures across all waves. A
+// server whose dependencies did not all succeed is skipped, and so is
+// everything that depends on it.
+func dependencyExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	deps, err := resolveDependencies(req)
+	if err != nil {
+		return nil, err
+	}
+
+	waves, err := dependencyWaves(req.Servers, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Starting dependency-ordered execution", "servers", len(req.Servers), "waves", len(waves))
+
+	var results []models.ExecutionResult
+	succeeded := make(map[string]bool)
+
+	for i, wave := range waves {
+		var ready []string
+		for _, serverID := range wave {
+			if dep := unmetDependency(deps[serverID], succeeded); dep != "" {
+				result := models.ExecutionResult{
+					ServerID: serverID,
+					Skipped:  true,
+					Error:    fmt.Sprintf("skipped: dependency %s did not succeed", dep),
+				}
+				logger.Warn("Skipping server", "serverID", serverID, "dependency", dep)
+				state.serverSkipped(result)
+				results = append(results, result)
+				continue
+			}
+			ready = append(ready, serverID)
+		}
+
+		if len(ready) == 0 {
+			continue
+		}
+
+		logger.Info("Starting wave", "wave", i+1, "servers", ready)
+
+		waveReq := req
+		waveReq.Servers = ready
+		waveReq.DependsOn = nil
+
+		waveResults, err := runStrategy(ctx, waveReq, budget, state)
+		results = append(results, waveResults...)
+		for _, result := range waveResults {
+			if result.Success {
+				succeeded[result.ServerID] = true
+			}
+		}
+
+		if err != nil {
+			// The wave only rolled back its own servers, so roll back the
+			// earlier waves as well
+			if state.abort == nil {
+				state.rollbackServers(ctx, req.Steps, results, err.Error())
+			}
+			return results, err
+		}
+		if err := state.abortError(); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// unmetDependency returns the first dependency that has not succeeded, or ""
+func unmetDependency(deps []string, succeeded map[string]bool) string {
+	for _, dep := range deps {
+		if !succeeded[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// resolveDependencies expands the server IDs and label selectors in
+// req.DependsOn into the servers each server waits for, listed in request
+// order. On a retry, references to servers outside the retried set are
+// dropped, since those servers succeeded in the original run.
+func resolveDependencies(req models.ExecutionRequest) (map[string][]string, error) {
+	// Sort the keys so that errors are reported deterministically
+	keys := make([]string, 0, len(req.DependsOn))
+	for key := range req.DependsOn {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	edges := make(map[string]map[string]bool)
+	for _, key := range keys {
+		dependents, err := matchServers(req, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ref := range req.DependsOn[key] {
+			dependencies, err := matchServers(req, ref)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, dependent := range dependents {
+				for _, dependency := range dependencies {
+					if dependent == dependency {
+						continue
+					}
+					if edges[dependent] == nil {
+						edges[dependent] = make(map[string]bool)
+					}
+					edges[dependent][dependency] = true
+				}
+			}
+		}
+	}
+
+	deps := make(map[string][]string)
+	for _, dependent := range req.Servers {
+		for _, serverID := range req.Servers {
+			if edges[dependent][serverID] {
+				deps[dependent] = append(deps[dependent], serverID)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// matchServers returns the servers of the request a DependsOn reference
+// selects: a "key=value" label selector or a server ID
+func matchServers(req models.ExecutionRequest, ref string) ([]string, error) {
+	var matched []string
+
+	if key, value, ok := strings.Cut(ref, "="); ok {
+		for _, serverID := range req.Servers {
+			if labels, ok := req.ServerLabels[serverID]; ok && labels[key] == value {
+				matched = append(matched, serverID)
+			}
+		}
+	} else {
+		for _, serverID := range req.Servers {
+			if serverID == ref {
+				matched = append(matched, serverID)
+			}
+		}
+	}
+
+	if len(matched) == 0 && req.RetryOf == nil {
+		return nil, fmt.Errorf("dependsOn reference %q matches no servers", ref)
+	}
+	return matched, nil
+}
+
+// dependencyWaves orders the servers into waves where every server only
+// depends on servers of earlier waves. Servers keep their request order
+// within a wave.
+func dependencyWaves(servers []string, deps map[string][]string) ([][]string, error) {
+	done := make(map[string]bool)
+	var waves [][]string
+
+	for len(done) < len(servers) {
+		var wave []string
+		for _, serverID := range servers {
+			if !done[serverID] && unmetDependency(deps[serverID], done) == "" {
+				wave = append(wave, serverID)
+			}
+		}
+
+		if len(wave) == 0 {
+			var cycle []string
+			for _, serverID := range servers {
+				if !done[serverID] {
+					cycle = append(cycle, serverID)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between servers: %s", strings.Join(cycle, ", "))
+		}
+
+		for _, serverID := range wave {
+			done[serverID] = true
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
@@ -0,0 +1,148 @@
+package workflows
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+func tieredRequest() models.ExecutionRequest {
+	return models.ExecutionRequest{
+		Servers: []string{"lb-1", "app-1", "app-2", "db-1"},
+		ServerLabels: map[string]map[string]string{
+			"lb-1":  {"role": "lb"},
+			"app-1": {"role": "app"},
+			"app-2": {"role": "app"},
+			"db-1":  {"role": "db"},
+		},
+		DependsOn: map[string][]string{
+			"role=app": {"role=db"},
+			"lb-1":     {"role=app"},
+		},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Parallel"},
+	}
+}
+
+func TestDependencyWaves(t *testing.T) {
+	req := tieredRequest()
+
+	deps, err := resolveDependencies(req)
+	if err != nil {
+		t.Fatalf("Failed to resolve dependencies: %v", err)
+	}
+
+	waves, err := dependencyWaves(req.Servers, deps)
+	if err != nil {
+		t.Fatalf("Failed to order servers: %v", err)
+	}
+
+	expected := [][]string{{"db-1"}, {"app-1", "app-2"}, {"lb-1"}}
+	if !reflect.DeepEqual(waves, expected) {
+		t.Errorf("Expected waves %v, got %v", expected, waves)
+	}
+}
+
+func TestDependencyWaves_Cycle(t *testing.T) {
+	req := tieredRequest()
+	req.DependsOn["role=db"] = []string{"lb-1"}
+
+	deps, err := resolveDependencies(req)
+	if err != nil {
+		t.Fatalf("Failed to resolve dependencies: %v", err)
+	}
+
+	if _, err := dependencyWaves(req.Servers, deps); err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("Expected dependency cycle error, got: %v", err)
+	}
+}
+
+func TestResolveDependencies_UnknownReference(t *testing.T) {
+	req := tieredRequest()
+	req.DependsOn["lb-1"] = []string{"role=cache"}
+
+	if _, err := resolveDependencies(req); err == nil {
+		t.Error("Expected error for a selector matching no servers")
+	}
+
+	// A retry only targets the servers that did not succeed, so references
+	// to the others are satisfied
+	req.RetryOf = &models.OrchestrationRef{WorkflowID: "previous"}
+	if _, err := resolveDependencies(req); err != nil {
+		t.Errorf("Expected references outside a retry to be ignored, got: %v", err)
+	}
+}
+
+func TestOrchestrationWorkflow_DependsOnSkipsDependentsOfFailures(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"app-2": true}}
+	env := newTestEnv(fake)
+
+	req := tieredRequest()
+	req.RolloutStrategy.MaxFailures = 1
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	if contains(fake.executed, "lb-1") {
+		t.Errorf("Expected lb-1 not to run after app-2 failed, got %v", fake.executed)
+	}
+	if fake.executed[0] != "db-1" {
+		t.Errorf("Expected db-1 to run first, got %v", fake.executed)
+	}
+	if result.Success || result.ServersPatched != 2 || result.ServersFailed != 1 || result.ServersSkipped != 1 {
+		t.Errorf("Expected 2 patched, 1 failed and 1 skipped, got %+v", result)
+	}
+}
+
+func TestOrchestrationWorkflow_DependsOnFailureThresholdSpansWaves(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"db-2": true, "app-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"db-1", "db-2", "db-3", "app-1", "app-2", "app-3"},
+		ServerLabels:    map[string]map[string]string{"app-1": {"role": "app"}, "app-2": {"role": "app"}, "app-3": {"role": "app"}},
+		DependsOn:       map[string][]string{"role=app": {"db-1"}},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Parallel", MaxFailures: 1},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if result.ServersFailed != 2 {
+		t.Errorf("Expected 2 failed servers, got %+v", result)
+	}
+	for _, serverID := range []string{"db-1", "db-3"} {
+		if !contains(fake.rolledBack, serverID+"/hello") {
+			t.Errorf("Expected earlier wave server %s to be rolled back, rolled back: %v", serverID, fake.rolledBack)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_DependsOnThresholdRollsBackEarlierWaves(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"app-1": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"db-1", "app-1"},
+		DependsOn:       map[string][]string{"app-1": {"db-1"}},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Parallel"},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if !contains(fake.rolledBack, "db-1/hello") || result.ServersRolledBack != 1 {
+		t.Errorf("Expected db-1 to be rolled back, rolled back: %v, result: %+v", fake.rolledBack, result)
+	}
+}
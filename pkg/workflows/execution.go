@@ -1,6 +1,7 @@
 package workflows
 
 import (
+	"errors"
 	"fmt"
 	"time"
 	
@@ -8,9 +9,16 @@ import (
 	"go.temporal.io/sdk/workflow"
 	
 	"github.com/melslow/kitsune/pkg/activities/handlers"
+	"github.com/melslow/kitsune/pkg/expr"
+	"github.com/melslow/kitsune/pkg/metrics"
 	"github.com/melslow/kitsune/pkg/models"
 )
 
+// defaultHeartbeatTimeout applies to the steps whose handlers heartbeat while
+// they run, so a lost worker is noticed within a minute instead of after the
+// full timeout
+const defaultHeartbeatTimeout = time.Minute
+
 type ExecutedStepInfo struct {
 	Step     models.StepDefinition
 	Metadata map[string]interface{}
@@ -19,31 +27,42 @@ type ExecutedStepInfo struct {
 type RollbackWorkflowInput struct {
 	ServerID      string
 	ExecutedSteps []ExecutedStepInfo
+	Audit         models.AuditInfo
 }
 
 // ServerExecutionWorkflow executes a list of steps on a single server
 func ServerExecutionWorkflow(ctx workflow.Context, input models.WorkflowInput) (models.ExecutionResult, error) {
 	logger := workflow.GetLogger(ctx)
-	result := models.ExecutionResult{
-		ServerID:      input.ServerID,
-		StepsExecuted: []models.StepResult{},
-	}
+	e := newServerExecution(input)
 	
 	logger.Info("Starting execution workflow", "serverID", input.ServerID, "steps", len(input.Steps))
 
+	err := workflow.SetQueryHandler(ctx, QueryProgress, e.currentProgress)
+	if err != nil {
+		return e.result, fmt.Errorf("failed to register progress query: %w", err)
+	}
+
 	// Validate all steps before execution
 	validator := handlers.NewStepValidator()
-	if err := validator.ValidateSteps(input.Steps); err != nil {
+	err = validator.ValidateSteps(input.Steps)
+	if err == nil {
+		err = validator.ValidateFinally(input.Steps, input.Finally)
+	}
+	if err != nil {
 		logger.Error("Step validation failed", "error", err)
-		result.Success = false
-		result.Error = fmt.Sprintf("step validation failed: %v", err)
-		return result, fmt.Errorf("step validation failed: %w", err)
+		workflow.GetMetricsHandler(ctx).Counter(metrics.ValidationFailures).Inc(1)
+		e.result.Success = false
+		e.result.Error = fmt.Sprintf("step validation failed: %v", err)
+		e.progress.Status = models.StatusFailed
+		return e.result, fmt.Errorf("step validation failed: %w", err)
 	}
 	logger.Info("All steps validated successfully")
 	
-	// Configure activity options
+	// Configure activity options. A cancelled step is waited for, so that its
+	// outcome is still recorded and compensated.
 	activityOptions := workflow.ActivityOptions{
 		StartToCloseTimeout: 5 * time.Minute,
+		WaitForCancellation: true,
 		RetryPolicy: &temporal.RetryPolicy{
 			InitialInterval:    time.Second,
 			BackoffCoefficient: 2.0,
@@ -53,44 +72,433 @@ func ServerExecutionWorkflow(ctx workflow.Context, input models.WorkflowInput) (
 	}
 	ctx = workflow.WithActivityOptions(ctx, activityOptions)
 	
-	// Execute each step
+	// Steps run on a disconnected context so that when the orchestrator cancels
+	// this workflow their outcomes are still collected and rolled back with the
+	// rest. The steps in flight are cancelled, which stops their handlers.
+	e.stepCtx, _ = workflow.NewDisconnectedContext(ctx)
+	workflow.Go(e.stepCtx, func(stepCtx workflow.Context) {
+		ctx.Done().Receive(stepCtx, nil)
+		for _, cancel := range e.cancels {
+			if cancel != nil {
+				cancel()
+			}
+		}
+	})
+	
+	if hasStepDependencies(input.Steps) {
+		err = e.runGraph(ctx)
+	} else {
+		err = e.runSequential(ctx)
+	}
+	if err != nil {
+		return e.result, err
+	}
+	
+	e.result.Success = true
+	e.progress.Status = models.StatusSucceeded
+	e.runFinally()
+	logger.Info("Execution workflow completed", "serverID", input.ServerID)
+	
+	return e.result, nil
+}
+
+// serverExecution holds the state of a ServerExecutionWorkflow run
+type serverExecution struct {
+	input    models.WorkflowInput
+	result   models.ExecutionResult
+	progress models.ExecutionProgress
+	
+	// stepCtx runs steps and rollbacks, unaffected by cancellation of the workflow
+	stepCtx workflow.Context
+	
+	// cancels the ExecuteStep activity of each step in flight, nil otherwise
+	cancels []workflow.CancelFunc
+	
+	// Steps that succeeded, in completion order, for compensation on failure
+	executedSteps   []ExecutedStepInfo
+	executedIndexes []int
+	
+	// outcomes of finished steps by name, exposed to conditions and templates as steps.*
+	stepOutcomes map[string]interface{}
+	
+	// steps with their parameter templates resolved by prepareStep
+	resolved []models.StepDefinition
+	
+	// when each step was started, unset for steps that did not run
+	started []*time.Time
+}
+
+func newServerExecution(input models.WorkflowInput) *serverExecution {
+	e := &serverExecution{
+		input: input,
+		result: models.ExecutionResult{
+			ServerID:      input.ServerID,
+			StepsExecuted: []models.StepResult{},
+		},
+		progress: models.ExecutionProgress{
+			ServerID: input.ServerID,
+			Status:   models.StatusRunning,
+			Steps:    make([]models.StepProgress, len(input.Steps)),
+		},
+		stepOutcomes: make(map[string]interface{}),
+		resolved:     append([]models.StepDefinition(nil), input.Steps...),
+		started:      make([]*time.Time, len(input.Steps)),
+		cancels:      make([]workflow.CancelFunc, len(input.Steps)),
+	}
 	for i, step := range input.Steps {
-		logger.Info("Executing step", "number", i+1, "name", step.Name, "type", step.Type)
+		e.progress.Steps[i] = models.StepProgress{Name: step.Name, Status: models.StatusPending}
+	}
+	return e
+}
+
+// currentProgress is the query handler for ServerExecutionWorkflow
+func (e *serverExecution) currentProgress() (models.ExecutionProgress, error) {
+	current := e.progress
+	current.Steps = append([]models.StepProgress(nil), e.progress.Steps...)
+	return current, nil
+}
+
+// runSequential executes the steps one after another in the order given
+func (e *serverExecution) runSequential(ctx workflow.Context) error {
+	for i := range e.input.Steps {
+		if ctx.Err() != nil {
+			return e.cancel(ctx)
+		}
+		
+		run, err := e.prepareStep(i)
+		if err != nil {
+			if failure := e.completeStep(ctx, i, nil, err); failure != nil {
+				return e.fail(failure)
+			}
+			continue
+		}
+		if !run {
+			e.conditionNotMet(ctx, i)
+			continue
+		}
 		
 		var metadata map[string]interface{}
-		err := workflow.ExecuteActivity(ctx, "ExecuteStep", input.ServerID, step).Get(ctx, &metadata)
+		err = e.startStep(ctx, i).Get(e.stepCtx, &metadata)
+		if failure := e.completeStep(ctx, i, metadata, err); failure != nil {
+			if ctx.Err() != nil {
+				// The step failed because the workflow was cancelled
+				return e.cancel(ctx)
+			}
+			return e.fail(failure)
+		}
+	}
+	return nil
+}
+
+// env is the data conditions and parameter templates are evaluated against:
+// the plan variables, the server's labels and the outcomes of earlier steps
+func (e *serverExecution) env() map[string]interface{} {
+	return map[string]interface{}{
+		"steps": e.stepOutcomes,
+		"vars":  e.input.Vars,
+		"server": map[string]interface{}{
+			"id":     e.input.ServerID,
+			"labels": e.input.Labels,
+		},
+	}
+}
+
+// prepareStep evaluates the When condition of the step at index i and, when
+// the step is to run, resolves the templates in its parameters
+func (e *serverExecution) prepareStep(i int) (bool, error) {
+	step, run, err := e.prepare(e.input.Steps[i])
+	if run {
+		e.resolved[i] = step
+	}
+	return run, err
+}
+
+// prepare evaluates the When condition of step and returns the step with its
+// parameter templates resolved, or false when it is not to run
+func (e *serverExecution) prepare(step models.StepDefinition) (models.StepDefinition, bool, error) {
+	env := e.env()
+	
+	if step.When != "" {
+		condition, err := expr.Parse(step.When)
+		if err != nil {
+			return step, false, err
+		}
+		run, err := condition.EvalBool(env)
+		if err != nil || !run {
+			return step, false, err
+		}
+	}
+	
+	rendered, err := expr.RenderValue(step.Params, env)
+	if err != nil {
+		return step, false, fmt.Errorf("failed to resolve parameters: %w", err)
+	}
+	if params, ok := rendered.(map[string]interface{}); ok {
+		step.Params = params
+	}
+	return step, true, nil
+}
+
+// conditionNotMet records that the step at index i was skipped because its
+// When condition was false. This is not a failure, even for required steps.
+func (e *serverExecution) conditionNotMet(ctx workflow.Context, i int) {
+	step := e.input.Steps[i]
+	workflow.GetLogger(ctx).Info("Skipping step, condition not met", "step", step.Name, "when", step.When)
+	
+	e.progress.Steps[i].Status = models.StatusSkipped
+	e.result.StepsExecuted = append(e.result.StepsExecuted, models.StepResult{
+		Name:    step.Name,
+		Skipped: true,
+	})
+	e.stepOutcomes[step.Name] = map[string]interface{}{"status": models.StatusSkipped}
+}
+
+// startStep starts the ExecuteStep activity for the step at index i, which
+// must have been prepared
+func (e *serverExecution) startStep(ctx workflow.Context, i int) workflow.Future {
+	step := e.resolved[i]
+	workflow.GetLogger(ctx).Info("Executing step", "number", i+1, "name", step.Name, "type", step.Type)
+	e.progress.Steps[i].Status = models.StatusRunning
+	startedAt := workflow.Now(ctx)
+	e.started[i] = &startedAt
+	
+	activityCtx, cancel := workflow.WithCancel(e.stepCtx)
+	e.cancels[i] = cancel
+	return workflow.ExecuteActivity(withStepOptions(activityCtx, step), "ExecuteStep", e.input.ServerID, step, e.input.Audit)
+}
+
+// withStepOptions applies the timeouts and retry policy of a step on top of the
+// activity options of ctx. Steps are validated first, so their durations parse.
+func withStepOptions(ctx workflow.Context, step models.StepDefinition) workflow.Context {
+	options := workflow.GetActivityOptions(ctx)
+	
+	if step.Timeout != "" {
+		options.StartToCloseTimeout, _ = time.ParseDuration(step.Timeout)
+	}
+	if step.HeartbeatTimeout != "" {
+		options.HeartbeatTimeout, _ = time.ParseDuration(step.HeartbeatTimeout)
+	} else if handlers.Heartbeats(step.Type) {
+		options.HeartbeatTimeout = defaultHeartbeatTimeout
+	}
+	
+	if retry := step.Retry; retry != nil {
+		policy := temporal.RetryPolicy{}
+		if options.RetryPolicy != nil {
+			policy = *options.RetryPolicy
+		}
+		if retry.MaxAttempts > 0 {
+			policy.MaximumAttempts = int32(retry.MaxAttempts)
+		}
+		if retry.InitialInterval != "" {
+			policy.InitialInterval, _ = time.ParseDuration(retry.InitialInterval)
+		}
+		if retry.BackoffCoefficient != 0 {
+			policy.BackoffCoefficient = retry.BackoffCoefficient
+		}
+		if retry.MaxInterval != "" {
+			policy.MaximumInterval, _ = time.ParseDuration(retry.MaxInterval)
+		}
+		if len(retry.NonRetryableErrorTypes) > 0 {
+			policy.NonRetryableErrorTypes = retry.NonRetryableErrorTypes
+		}
+		options.RetryPolicy = &policy
+	}
+	
+	return workflow.WithActivityOptions(ctx, options)
+}
+
+// completeStep records the outcome of the step at index i. It returns an error
+// when the step was required, in which case the execution must stop.
+func (e *serverExecution) completeStep(ctx workflow.Context, i int, metadata map[string]interface{}, err error) error {
+	logger := workflow.GetLogger(ctx)
+	step := e.resolved[i]
+	e.cancels[i] = nil
+	if err != nil && metadata == nil {
+		metadata = failureMetadata(err)
+	}
+	
+	stepResult := models.StepResult{
+		Name:     step.Name,
+		Metadata: metadata,
+	}
+	if e.started[i] != nil {
+		finishedAt := workflow.Now(ctx)
+		stepResult.StartedAt = e.started[i]
+		stepResult.FinishedAt = &finishedAt
+	}
+	
+	// Record the parameters the step ran with when they came from templates
+	if templates, _ := expr.Templates(e.input.Steps[i].Params); len(templates) > 0 && err == nil {
+		stepResult.Params = step.Params
+	}
+	
+	outcome := map[string]interface{}{
+		"status":  models.StatusSucceeded,
+		"outputs": metadata,
+	}
+	e.stepOutcomes[step.Name] = outcome
+	
+	if err == nil {
+		stepResult.Success = true
+		e.progress.Steps[i].Status = models.StatusSucceeded
+		e.executedSteps = append(e.executedSteps, ExecutedStepInfo{Step: step, Metadata: metadata})
+		e.executedIndexes = append(e.executedIndexes, i)
+		e.result.StepsExecuted = append(e.result.StepsExecuted, stepResult)
+		return nil
+	}
+	
+	outcome["status"] = models.StatusFailed
+	stepResult.Success = false
+	stepResult.Error = err.Error()
+	e.progress.Steps[i].Status = models.StatusFailed
+	e.progress.Steps[i].Error = err.Error()
+	e.result.StepsExecuted = append(e.result.StepsExecuted, stepResult)
+	
+	if step.Required && !step.ContinueOnFailure {
+		logger.Error("Required step failed", "step", step.Name, "error", err)
+		e.result.Error = fmt.Sprintf("Required step '%s' failed: %v", step.Name, err)
+		return err
+	}
+	
+	logger.Warn("Step failed but continuing", "step", step.Name)
+	return nil
+}
+
+// failureMetadata returns the outputs a handler attached to the error of a
+// failed step, such as the exit code and output of a script
+func failureMetadata(err error) map[string]interface{} {
+	var appErr *temporal.ApplicationError
+	var metadata map[string]interface{}
+	if errors.As(err, &appErr) && appErr.HasDetails() && appErr.Details(&metadata) == nil {
+		return metadata
+	}
+	return nil
+}
+
+// skipStep records that the step at index i did not run. Like completeStep it
+// returns an error when the step was required.
+func (e *serverExecution) skipStep(ctx workflow.Context, i int, reason string) error {
+	step := e.input.Steps[i]
+	workflow.GetLogger(ctx).Warn("Skipping step", "step", step.Name, "reason", reason)
+	
+	e.progress.Steps[i].Status = models.StatusSkipped
+	e.progress.Steps[i].Error = reason
+	e.result.StepsExecuted = append(e.result.StepsExecuted, models.StepResult{
+		Name:    step.Name,
+		Skipped: true,
+		Error:   reason,
+	})
+	e.stepOutcomes[step.Name] = map[string]interface{}{"status": models.StatusSkipped}
+	
+	if step.Required && !step.ContinueOnFailure {
+		e.result.Error = fmt.Sprintf("Required step '%s' skipped: %s", step.Name, reason)
+		return errors.New(reason)
+	}
+	return nil
+}
+
+// fail compensates the steps applied so far after a required step failed and
+// returns the error the workflow fails with
+func (e *serverExecution) fail(err error) error {
+	e.progress.Status = models.StatusFailed
+	
+	// Compensate the steps already applied to this server
+	e.compensate(e.result.Error)
+	e.runFinally()
+	
+	// Attach the result so the orchestrator sees the step and rollback outcomes
+	return temporal.NewNonRetryableApplicationError(e.result.Error, "RequiredStepFailed", err, e.result)
+}
+
+// cancel compensates the steps applied so far once the orchestrator has
+// cancelled the workflow and returns the error the workflow is cancelled with
+func (e *serverExecution) cancel(ctx workflow.Context) error {
+	workflow.GetLogger(ctx).Warn("Execution cancelled, rolling back executed steps", "serverID", e.input.ServerID, "executed", len(e.executedSteps))
+	e.result.Cancelled = true
+	e.result.Error = "execution cancelled by orchestrator"
+	e.progress.Status = models.StatusCancelled
+	for i := range e.progress.Steps {
+		if e.progress.Steps[i].Status == models.StatusPending {
+			e.progress.Steps[i].Status = models.StatusCancelled
+		}
+	}
+	e.compensate(e.result.Error)
+	e.runFinally()
+	
+	// Attach the result so the orchestrator sees what was applied and undone
+	return temporal.NewCanceledError(e.result)
+}
+
+// runFinally runs the finally steps in order once the main steps are done,
+// after any compensation. They run on the disconnected context, so they also
+// run after a cancellation. A failed finally step is reported but neither
+// stops the others nor changes the outcome of the execution, and finally steps
+// are never rolled back.
+func (e *serverExecution) runFinally() {
+	logger := workflow.GetLogger(e.stepCtx)
+	
+	for _, step := range e.input.Finally {
+		stepResult := models.StepResult{Name: step.Name}
 		
-		stepResult := models.StepResult{
-			Name: step.Name,
+		resolved, run, err := e.prepare(step)
+		if err == nil && !run {
+			logger.Info("Skipping finally step, condition not met", "step", step.Name, "when", step.When)
+			stepResult.Skipped = true
+			e.result.Finally = append(e.result.Finally, stepResult)
+			e.stepOutcomes[step.Name] = map[string]interface{}{"status": models.StatusSkipped}
+			continue
 		}
 		
-		if err != nil {
-			stepResult.Success = false
-			stepResult.Error = err.Error()
-			
-			if step.Required && !step.ContinueOnFailure {
-				logger.Error("Required step failed", "step", step.Name, "error", err)
-				result.Error = fmt.Sprintf("Required step '%s' failed: %v", step.Name, err)
-				result.StepsExecuted = append(result.StepsExecuted, stepResult)
-				return result, err
+		var metadata map[string]interface{}
+		if err == nil {
+			logger.Info("Executing finally step", "name", step.Name, "type", step.Type)
+			startedAt := workflow.Now(e.stepCtx)
+			err = workflow.ExecuteActivity(withStepOptions(e.stepCtx, resolved), "ExecuteStep", e.input.ServerID, resolved, e.input.Audit).Get(e.stepCtx, &metadata)
+			if err != nil {
+				metadata = failureMetadata(err)
 			}
-			
-			logger.Warn("Step failed but continuing", "step", step.Name)
-		} else {
-			stepResult.Success = true
+			finishedAt := workflow.Now(e.stepCtx)
+			stepResult.StartedAt = &startedAt
+			stepResult.FinishedAt = &finishedAt
 		}
 		
-		result.StepsExecuted = append(result.StepsExecuted, stepResult)
+		stepResult.Success = err == nil
+		stepResult.Metadata = metadata
+		status := models.StatusSucceeded
+		if err != nil {
+			logger.Warn("Finally step failed", "step", step.Name, "error", err)
+			stepResult.Error = err.Error()
+			status = models.StatusFailed
+		}
+		e.result.Finally = append(e.result.Finally, stepResult)
+		e.stepOutcomes[step.Name] = map[string]interface{}{"status": status, "outputs": metadata}
+	}
+}
+
+// compensate rolls back the steps already applied to this server, most
+// recently completed first
+func (e *serverExecution) compensate(reason string) {
+	if len(e.executedSteps) == 0 {
+		return
 	}
 	
-	result.Success = true
-	logger.Info("Execution workflow completed", "serverID", input.ServerID)
-	
-	return result, nil
+	rollback := rollbackSteps(e.stepCtx, e.input.ServerID, e.executedSteps, e.input.Audit)
+	rollback.Reason = reason
+	e.result.Rollback = &rollback
+	for j, stepRollback := range rollback.Steps {
+		// rollback.Steps is in reverse completion order
+		index := e.executedIndexes[len(e.executedIndexes)-1-j]
+		if stepRollback.Success {
+			e.progress.Steps[index].Status = models.StatusRolledBack
+		} else {
+			e.progress.Steps[index].Status = models.StatusRollbackFailed
+			e.progress.Steps[index].Error = stepRollback.Error
+		}
+	}
 }
 
 // ServerRollbackWorkflow executes rollback steps for a server
-func ServerRollbackWorkflow(ctx workflow.Context, input RollbackWorkflowInput) error {
+func ServerRollbackWorkflow(ctx workflow.Context, input RollbackWorkflowInput) (models.RollbackResult, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting rollback workflow", "serverID", input.ServerID, "steps", len(input.ExecutedSteps))
 	
@@ -106,19 +514,47 @@ func ServerRollbackWorkflow(ctx workflow.Context, input RollbackWorkflowInput) e
 	}
 	ctx = workflow.WithActivityOptions(ctx, activityOptions)
 	
-	rollbackSteps(ctx, input.ServerID, input.ExecutedSteps)
+	result := rollbackSteps(ctx, input.ServerID, input.ExecutedSteps, input.Audit)
+	if !result.Success {
+		result.Error = "one or more steps failed to roll back"
+		logger.Error("Rollback workflow completed with failures", "serverID", input.ServerID)
+		return result, temporal.NewNonRetryableApplicationError(result.Error, "RollbackFailed", nil, result)
+	}
 	
 	logger.Info("Rollback workflow completed", "serverID", input.ServerID)
-	return nil
+	return result, nil
 }
 
-func rollbackSteps(ctx workflow.Context, serverID string, steps []ExecutedStepInfo) {
+// rollbackSteps rolls back the given steps in reverse order. A failed rollback
+// does not stop the remaining steps from being rolled back.
+func rollbackSteps(ctx workflow.Context, serverID string, steps []ExecutedStepInfo, audit models.AuditInfo) models.RollbackResult {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Rolling back steps", "count", len(steps))
 	
+	result := models.RollbackResult{
+		ServerID:  serverID,
+		Success:   true,
+		Steps:     []models.StepRollbackResult{},
+		StartedAt: workflow.Now(ctx),
+	}
+	
 	for i := len(steps) - 1; i >= 0; i-- {
 		stepInfo := steps[i]
 		logger.Info("Rolling back step", "step", stepInfo.Step.Name)
-		workflow.ExecuteActivity(ctx, "RollbackStep", serverID, stepInfo.Step, stepInfo.Metadata).Get(ctx, nil)
+		err := workflow.ExecuteActivity(withStepOptions(ctx, stepInfo.Step), "RollbackStep", serverID, stepInfo.Step, stepInfo.Metadata, audit).Get(ctx, nil)
+		
+		stepResult := models.StepRollbackResult{
+			Name:    stepInfo.Step.Name,
+			Success: err == nil,
+		}
+		if err != nil {
+			logger.Error("Step rollback failed", "step", stepInfo.Step.Name, "error", err)
+			stepResult.Error = err.Error()
+			result.Success = false
+		}
+		result.Steps = append(result.Steps, stepResult)
 	}
+	
+	result.CompletedAt = workflow.Now(ctx)
+	return result
 }
\ No newline at end of file
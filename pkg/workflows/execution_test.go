@@ -0,0 +1,513 @@
+package workflows
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+func TestServerExecutionWorkflow_ProgressQuery(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-1": true}}
+	env := newTestEnv(fake)
+
+	steps := append(echoSteps(), models.StepDefinition{
+		Name:   "never-runs",
+		Type:   "echo",
+		Params: map[string]interface{}{"message": "unreachable"},
+	})
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected required step failure")
+	}
+
+	value, err := env.QueryWorkflow(QueryProgress)
+	if err != nil {
+		t.Fatalf("Failed to query progress: %v", err)
+	}
+
+	var progress models.ExecutionProgress
+	if err := value.Get(&progress); err != nil {
+		t.Fatalf("Failed to decode progress: %v", err)
+	}
+
+	if progress.Status != models.StatusFailed {
+		t.Errorf("Expected failed status, got: %s", progress.Status)
+	}
+
+	if progress.Steps[0].Status != models.StatusFailed || progress.Steps[1].Status != models.StatusPending {
+		t.Errorf("Unexpected step statuses: %+v", progress.Steps)
+	}
+}
+
+func TestServerExecutionWorkflow_RequiredFailureRollsBackServer(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"install": true}}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "drain", Type: "echo", Params: map[string]interface{}{"message": "drain"}, Required: true},
+		{Name: "optional", Type: "echo", Params: map[string]interface{}{"message": "optional"}},
+		{Name: "install", Type: "echo", Params: map[string]interface{}{"message": "install"}, Required: true},
+		{Name: "never-runs", Type: "echo", Params: map[string]interface{}{"message": "unreachable"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected required step failure")
+	}
+
+	// The orchestrator recovers the full result from the error
+	result := executionResultFromError("server-1", err)
+
+	if result.Rollback == nil || !result.Rollback.Success {
+		t.Fatalf("Expected successful server rollback, got: %+v", result.Rollback)
+	}
+
+	expected := []string{"server-1/optional", "server-1/drain"}
+	if len(fake.rolledBack) != len(expected) {
+		t.Fatalf("Expected rollback of %v, got: %v", expected, fake.rolledBack)
+	}
+	for i, name := range expected {
+		if fake.rolledBack[i] != name || result.Rollback.Steps[i].Name != strings.TrimPrefix(name, "server-1/") {
+			t.Errorf("Expected rollback %d to be %s, got: %v / %+v", i, name, fake.rolledBack, result.Rollback.Steps)
+		}
+	}
+
+	if len(result.StepsExecuted) != 3 || result.StepsExecuted[2].Success {
+		t.Errorf("Expected three executed steps ending with the failure, got: %+v", result.StepsExecuted)
+	}
+}
+
+func TestServerExecutionWorkflow_StepGraph(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"install": true}}
+	env := newTestEnv(fake)
+
+	// fetch-a takes longer than fetch-b, so the two only finish in this order
+	// when they run concurrently
+	named := func(name string) interface{} {
+		return mock.MatchedBy(func(step models.StepDefinition) bool { return step.Name == name })
+	}
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, named("fetch-a"), mock.Anything).After(time.Hour).Return(fake.ExecuteStep)
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fake.ExecuteStep)
+
+	steps := []models.StepDefinition{
+		{Name: "fetch-a", Type: "echo", Params: map[string]interface{}{"message": "a"}, Required: true},
+		{Name: "fetch-b", Type: "echo", Params: map[string]interface{}{"message": "b"}, Required: true},
+		{Name: "install", Type: "echo", Params: map[string]interface{}{"message": "install"}, Required: true, DependsOn: []string{"fetch-a", "fetch-b"}},
+		{Name: "verify", Type: "echo", Params: map[string]interface{}{"message": "verify"}, DependsOn: []string{"install"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected required step failure")
+	}
+	result := executionResultFromError("server-1", err)
+
+	var completed []string
+	for _, stepResult := range result.StepsExecuted {
+		completed = append(completed, stepResult.Name)
+	}
+	if strings.Join(completed, ",") != "fetch-b,fetch-a,install" {
+		t.Errorf("Expected steps to complete as fetch-b, fetch-a, install, got %v", completed)
+	}
+
+	// Rollback runs in reverse completion order
+	expected := []string{"server-1/fetch-a", "server-1/fetch-b"}
+	if strings.Join(fake.rolledBack, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected rollback of %v, got %v", expected, fake.rolledBack)
+	}
+}
+
+func TestServerExecutionWorkflow_StepGraphSkipsDependents(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"optional": true}}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "optional", Type: "echo", Params: map[string]interface{}{"message": "optional"}},
+		{Name: "after-optional", Type: "echo", Params: map[string]interface{}{"message": "after"}, DependsOn: []string{"optional"}},
+		{Name: "independent", Type: "echo", Params: map[string]interface{}{"message": "independent"}, Required: true},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.ExecutionResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	var skipped *models.StepResult
+	for i := range result.StepsExecuted {
+		if result.StepsExecuted[i].Name == "after-optional" {
+			skipped = &result.StepsExecuted[i]
+		}
+	}
+	if skipped == nil || !skipped.Skipped {
+		t.Errorf("Expected after-optional to be recorded as skipped, got %+v", result.StepsExecuted)
+	}
+}
+
+func TestServerExecutionWorkflow_Conditions(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "upgrade", Type: "echo", Params: map[string]interface{}{"message": "upgrade"}, Required: true},
+		{Name: "restart", Type: "echo", Params: map[string]interface{}{"message": "restart"}, Required: true,
+			When: "steps.upgrade.outputs.previous_version == '1.0-server-1' && vars.restart"},
+		{Name: "canary-only", Type: "echo", Params: map[string]interface{}{"message": "canary"}, Required: true,
+			When: "server.labels.tier == 'canary'"},
+		{Name: "verify", Type: "echo", Params: map[string]interface{}{"message": "verify"},
+			When: "steps.canary-only.status != 'skipped'"},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+		Vars:     map[string]interface{}{"restart": true},
+		Labels:   map[string]string{"tier": "main"},
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.ExecutionResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	if len(result.StepsExecuted) != 4 {
+		t.Fatalf("Expected every step to be recorded, got %+v", result.StepsExecuted)
+	}
+	for i, skipped := range []bool{false, false, true, true} {
+		stepResult := result.StepsExecuted[i]
+		if stepResult.Skipped != skipped || stepResult.Success == skipped {
+			t.Errorf("Expected step %s skipped=%v, got %+v", stepResult.Name, skipped, stepResult)
+		}
+	}
+	if fake.executedCount() != 2 {
+		t.Errorf("Expected 2 steps to run, got %d", fake.executedCount())
+	}
+}
+
+func TestServerExecutionWorkflow_StepGraphRunsDependentsOfConditionSkips(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "maybe", Type: "echo", Params: map[string]interface{}{"message": "maybe"}, When: "vars.enabled"},
+		{Name: "after", Type: "echo", Params: map[string]interface{}{"message": "after"}, Required: true, DependsOn: []string{"maybe"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	if fake.executedCount() != 1 {
+		t.Errorf("Expected only the dependent step to run, got %d", fake.executedCount())
+	}
+}
+
+func TestServerExecutionWorkflow_TemplatedParams(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	var messages []interface{}
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, serverID string, step models.StepDefinition, auditInfo models.AuditInfo) (map[string]interface{}, error) {
+			messages = append(messages, step.Params["message"])
+			return fake.ExecuteStep(ctx, serverID, step, auditInfo)
+		})
+
+	steps := []models.StepDefinition{
+		{Name: "fetch-version", Type: "echo", Params: map[string]interface{}{"message": "fetch"}, Required: true},
+		{Name: "install", Type: "echo", Params: map[string]interface{}{"message": "install {{ steps.fetch-version.outputs.previous_version }} on {{ server.id }}"}, Required: true},
+		{Name: "record", Type: "echo", Params: map[string]interface{}{"message": "{{ vars.release }}"}},
+		{Name: "motd", Type: "echo", Params: map[string]interface{}{"message": "Hello {{ name }} from {{ server.id }}"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+		Vars:     map[string]interface{}{"release": "r42"},
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	expected := []interface{}{"fetch", "install 1.0-server-1 on server-1", "r42", "Hello {{ name }} from server-1"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected steps to run with messages %v, got %v", expected, messages)
+	}
+
+	var result models.ExecutionResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	if result.StepsExecuted[0].Params != nil {
+		t.Errorf("Expected no resolved params for a step without templates, got %v", result.StepsExecuted[0].Params)
+	}
+	if got := result.StepsExecuted[1].Params["message"]; got != expected[1] {
+		t.Errorf("Expected resolved params to be recorded, got %v", got)
+	}
+}
+
+func TestServerExecutionWorkflow_TemplateWithoutValueFailsStep(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "fetch-version", Type: "echo", Params: map[string]interface{}{"message": "fetch"}, Required: true},
+		{Name: "install", Type: "echo", Params: map[string]interface{}{"message": "{{ steps.fetch-version.outputs.version }}"}, Required: true},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected the unresolved template to fail the required step")
+	}
+	if !strings.Contains(err.Error(), "failed to resolve parameters") {
+		t.Errorf("Expected a template error, got: %v", err)
+	}
+	if fake.executedCount() != 1 {
+		t.Errorf("Expected install not to run, got %d executions", fake.executedCount())
+	}
+	if strings.Join(fake.rolledBack, ",") != "server-1/fetch-version" {
+		t.Errorf("Expected fetch-version to be rolled back, got %v", fake.rolledBack)
+	}
+}
+
+func TestServerExecutionWorkflow_StepRetryPolicy(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"migrate": true, "flaky": true}}
+	env := newTestEnv(fake)
+
+	steps := []models.StepDefinition{
+		{Name: "flaky", Type: "echo", Params: map[string]interface{}{"message": "flaky"},
+			Retry: &models.StepRetryPolicy{MaxAttempts: 5, InitialInterval: "1s", MaxInterval: "2s"}},
+		{Name: "migrate", Type: "echo", Params: map[string]interface{}{"message": "migrate"},
+			Retry: &models.StepRetryPolicy{MaxAttempts: 1}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	if fake.executedCount() != 6 {
+		t.Errorf("Expected 5 attempts of flaky and 1 of migrate, got %d", fake.executedCount())
+	}
+}
+
+func TestServerExecutionWorkflow_StepTimeouts(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	timeouts := make(map[string][2]time.Duration)
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		var serverID string
+		var step models.StepDefinition
+		if err := args.Get(&serverID, &step); err != nil {
+			t.Errorf("Failed to decode activity args: %v", err)
+			return
+		}
+		timeouts[step.Name] = [2]time.Duration{info.Deadline.Sub(info.StartedTime).Round(time.Second), info.HeartbeatTimeout}
+	})
+
+	steps := []models.StepDefinition{
+		{Name: "kernel-update", Type: "echo", Params: map[string]interface{}{"message": "update"}, Timeout: "30m", HeartbeatTimeout: "45s"},
+		{Name: "echo", Type: "echo", Params: map[string]interface{}{"message": "echo"}},
+		{Name: "deploy", Type: "script", Params: map[string]interface{}{"script": "/opt/bin/deploy"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    steps,
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	if got := timeouts["kernel-update"]; got != [2]time.Duration{30 * time.Minute, 45 * time.Second} {
+		t.Errorf("Expected kernel-update to use its own timeouts, got %v", got)
+	}
+	if got := timeouts["echo"]; got != [2]time.Duration{5 * time.Minute, 0} {
+		t.Errorf("Expected echo to use the default timeout without a heartbeat timeout, got %v", got)
+	}
+	if got := timeouts["deploy"]; got != [2]time.Duration{5 * time.Minute, time.Minute} {
+		t.Errorf("Expected the heartbeating script to get the default heartbeat timeout, got %v", got)
+	}
+}
+
+// recordActivities records "<activity type>:<step name>" for each activity started
+func recordActivities(t *testing.T, env *testsuite.TestWorkflowEnvironment) *[]string {
+	var started []string
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		var serverID string
+		var step models.StepDefinition
+		if err := args.Get(&serverID, &step); err != nil {
+			t.Errorf("Failed to decode activity args: %v", err)
+			return
+		}
+		if info.Attempt == 1 {
+			started = append(started, info.ActivityType.Name+":"+step.Name)
+		}
+	})
+	return &started
+}
+
+func TestServerExecutionWorkflow_FinallyRunsAfterRollback(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"upgrade": true, "enable-monitoring": true}}
+	env := newTestEnv(fake)
+	started := recordActivities(t, env)
+
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps: []models.StepDefinition{
+			{Name: "drain", Type: "echo", Params: map[string]interface{}{"message": "drain"}, Required: true},
+			{Name: "upgrade", Type: "echo", Params: map[string]interface{}{"message": "upgrade"}, Required: true},
+		},
+		Finally: []models.StepDefinition{
+			{Name: "enable-monitoring", Type: "echo", Params: map[string]interface{}{"message": "monitoring"}},
+			{Name: "undrain", Type: "echo", Params: map[string]interface{}{"message": "undrain"}, When: "steps.drain.status == 'succeeded'"},
+			{Name: "notify", Type: "echo", Params: map[string]interface{}{"message": "upgrade {{ steps.upgrade.status }}"}},
+		},
+	})
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected required step failure")
+	}
+	result := executionResultFromError("server-1", err)
+
+	expected := "ExecuteStep:drain,ExecuteStep:upgrade,RollbackStep:drain,ExecuteStep:enable-monitoring,ExecuteStep:undrain,ExecuteStep:notify"
+	if got := strings.Join(*started, ","); got != expected {
+		t.Errorf("Expected activities %s, got %s", expected, got)
+	}
+
+	if len(result.Finally) != 3 {
+		t.Fatalf("Expected 3 finally results, got %+v", result.Finally)
+	}
+	for i, success := range []bool{false, true, true} {
+		if result.Finally[i].Success != success {
+			t.Errorf("Expected finally step %s success=%v, got %+v", result.Finally[i].Name, success, result.Finally[i])
+		}
+	}
+	if len(result.StepsExecuted) != 2 {
+		t.Errorf("Expected finally steps to be reported separately, got %+v", result.StepsExecuted)
+	}
+}
+
+func TestServerExecutionWorkflow_FinallyFailureKeepsSuccess(t *testing.T) {
+	fake := &fakeStepActivities{failSteps: map[string]bool{"cleanup": true}}
+	env := newTestEnv(fake)
+
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps:    []models.StepDefinition{{Name: "upgrade", Type: "echo", Params: map[string]interface{}{"message": "upgrade"}, Required: true}},
+		Finally:  []models.StepDefinition{{Name: "cleanup", Type: "echo", Params: map[string]interface{}{"message": "cleanup"}, Required: true}},
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	var result models.ExecutionResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	if !result.Success || len(result.Finally) != 1 || result.Finally[0].Success {
+		t.Errorf("Expected a successful execution with a failed finally step, got %+v", result)
+	}
+	if len(fake.rolledBack) != 0 {
+		t.Errorf("Expected no rollback, got %v", fake.rolledBack)
+	}
+}
+
+func TestServerExecutionWorkflow_FinallyRunsOnCancellation(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.MatchedBy(func(step models.StepDefinition) bool {
+		return step.Name == "upgrade"
+	}), mock.Anything).After(time.Hour).Return(fake.ExecuteStep)
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fake.ExecuteStep)
+	env.RegisterDelayedCallback(env.CancelWorkflow, time.Minute)
+
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps: []models.StepDefinition{
+			{Name: "upgrade", Type: "echo", Params: map[string]interface{}{"message": "upgrade"}, Required: true},
+			{Name: "verify", Type: "echo", Params: map[string]interface{}{"message": "verify"}, Required: true},
+		},
+		Finally: []models.StepDefinition{{Name: "undrain", Type: "echo", Params: map[string]interface{}{"message": "undrain"}}},
+	})
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected the workflow to be cancelled")
+	}
+	result := executionResultFromError("server-1", err)
+	if !result.Cancelled {
+		t.Errorf("Expected a cancelled result, got %+v", result)
+	}
+	if len(result.Finally) != 1 || !result.Finally[0].Success {
+		t.Errorf("Expected undrain to run after cancellation, got %+v", result.Finally)
+	}
+}
+
+func TestServerExecutionWorkflow_FailedStepReportsOutputs(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		nil, temporal.NewNonRetryableApplicationError("script failed with exit code 3", "ScriptFailed", nil,
+			map[string]interface{}{"exit_code": 3, "stderr": "disk full"}))
+
+	steps := []models.StepDefinition{
+		{Name: "migrate", Type: "script", Params: map[string]interface{}{"script": "/opt/migrate.sh"}},
+	}
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{ServerID: "server-1", Steps: steps})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	var result models.ExecutionResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	step := result.StepsExecuted[0]
+	if step.Success || step.Metadata["exit_code"] != 3.0 || step.Metadata["stderr"] != "disk full" {
+		t.Errorf("Expected the script outputs on the failed step, got %+v", step)
+	}
+}
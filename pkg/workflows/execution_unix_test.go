@@ -0,0 +1,83 @@
+//go:build unix
+
+package workflows
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/melslow/kitsune/pkg/activities"
+	"github.com/melslow/kitsune/pkg/activities/handlers"
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+func TestServerExecutionWorkflow_CancelKillsRunningScript(t *testing.T) {
+	// Run the step with the real script handler
+	executeStep := func(ctx context.Context, serverID string, step models.StepDefinition, auditInfo models.AuditInfo) (activities.ExecutionMetadata, error) {
+		return (&handlers.ScriptHandler{}).Execute(ctx, step.Params)
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetTestTimeout(30 * time.Second)
+	env.RegisterWorkflow(ServerExecutionWorkflow)
+	env.RegisterActivityWithOptions(executeStep, activity.RegisterOptions{Name: "ExecuteStep"})
+
+	// The script starts a background process, which must be killed along with it
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	readPid := func() int {
+		data, err := os.ReadFile(pidFile)
+		if err != nil || !strings.HasSuffix(string(data), "\n") {
+			return 0
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		return pid
+	}
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); readPid() == 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		env.CancelWorkflow()
+	}()
+
+	env.ExecuteWorkflow(ServerExecutionWorkflow, models.WorkflowInput{
+		ServerID: "server-1",
+		Steps: []models.StepDefinition{{
+			Name:             "drain",
+			Type:             "script",
+			Params:           map[string]interface{}{"script": "sh", "args": []interface{}{"-c", "sleep 60 & echo $! > " + pidFile + "; wait"}},
+			Required:         true,
+			HeartbeatTimeout: "1s",
+		}},
+	})
+
+	result := executionResultFromError("server-1", env.GetWorkflowError())
+	if !result.Cancelled {
+		t.Errorf("Expected a cancelled result, got %+v", result)
+	}
+
+	pid := readPid()
+	if pid == 0 {
+		t.Fatal("Script did not start")
+	}
+
+	// The background process is gone, or a zombie waiting to be reaped
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Background process %d survived cancellation", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
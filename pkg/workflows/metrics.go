@@ -0,0 +1,26 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/metrics"
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// recordMetrics counts the servers of a finished orchestration and their
+// rollbacks by outcome, per rollout strategy
+func recordMetrics(ctx workflow.Context, req models.ExecutionRequest, result *models.OrchestrationResult) {
+	handler := workflow.GetMetricsHandler(ctx).WithTags(map[string]string{
+		metrics.TagStrategy: req.RolloutStrategy.Type,
+	})
+	count := func(name string, outcome string, n int) {
+		handler.WithTags(map[string]string{metrics.TagOutcome: outcome}).Counter(name).Inc(int64(n))
+	}
+
+	count(metrics.Servers, metrics.OutcomePatched, result.ServersPatched)
+	count(metrics.Servers, metrics.OutcomeFailed, result.ServersFailed)
+	count(metrics.Servers, metrics.OutcomeCancelled, result.ServersCancelled)
+	count(metrics.Servers, metrics.OutcomeSkipped, result.ServersSkipped)
+	count(metrics.ServerRollbacks, metrics.OutcomeSucceeded, result.ServersRolledBack)
+	count(metrics.ServerRollbacks, metrics.OutcomeFailed, result.RollbackFailures)
+}
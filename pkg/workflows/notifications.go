@@ -0,0 +1,46 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/notify"
+)
+
+// notify delivers a lifecycle event through the Notify activity without
+// waiting for it, so a slow or failing endpoint never holds up the rollout.
+// Delivery failures are logged and otherwise ignored.
+func (s *orchestrationState) notify(ctx workflow.Context, event notify.Event) {
+	info := workflow.GetInfo(ctx)
+	s.eventCount++
+	event.ID = fmt.Sprintf("%s-%d", info.WorkflowExecution.RunID, s.eventCount)
+	event.OrchestrationID = info.WorkflowExecution.ID
+	event.RunID = info.WorkflowExecution.RunID
+	event.Time = workflow.Now(ctx)
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+	s.notifications = append(s.notifications, workflow.ExecuteActivity(ctx, "Notify", event))
+}
+
+// flushNotifications waits for the events still being delivered, as pending
+// activities are abandoned once the workflow completes
+func (s *orchestrationState) flushNotifications(ctx workflow.Context) {
+	logger := workflow.GetLogger(ctx)
+	for _, f := range s.notifications {
+		if err := f.Get(ctx, nil); err != nil {
+			logger.Warn("Failed to deliver notification", "error", err)
+		}
+	}
+	s.notifications = nil
+}
@@ -1,197 +1,570 @@
 package workflows
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 
 	"github.com/melslow/kitsune/pkg/activities/handlers"
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
 	"github.com/melslow/kitsune/pkg/models"
+	"github.com/melslow/kitsune/pkg/notify"
 )
 
 // OrchestrationWorkflow coordinates execution across multiple servers
 func OrchestrationWorkflow(ctx workflow.Context, req models.ExecutionRequest) (*models.OrchestrationResult, error) {
 	logger := workflow.GetLogger(ctx)
+
+	// Re-run only the servers a previous orchestration did not patch
+	if req.RetryOf != nil {
+		retryReq, err := prepareRetry(ctx, *req.RetryOf)
+		if err != nil {
+			return nil, err
+		}
+		// The retried steps are audited as run by whoever requested the retry
+		retryReq.Requester = req.Requester
+		req = retryReq
+	}
+
 	logger.Info("Starting orchestration", "servers", len(req.Servers), "strategy", req.RolloutStrategy.Type)
 
 	// Validate all steps before dispatching to workers
 	validator := handlers.NewStepValidator()
-	if err := validator.ValidateSteps(req.Steps); err != nil {
+	err := validator.ValidateSteps(req.Steps)
+	if err == nil {
+		err = validator.ValidateFinally(req.Steps, req.Finally)
+	}
+	if err != nil {
 		logger.Error("Step validation failed", "error", err)
+		workflow.GetMetricsHandler(ctx).Counter(metrics.ValidationFailures).Inc(1)
 		return nil, fmt.Errorf("step validation failed: %w", err)
 	}
 	logger.Info("All steps validated successfully")
 
+	// Record the effective request so the orchestration can be retried later
+	if err := workflow.UpsertMemo(ctx, map[string]interface{}{models.RequestMemoKey: req}); err != nil {
+		return nil, fmt.Errorf("failed to record request: %w", err)
+	}
+
+	reusePolicy, err := parseWorkflowIDReusePolicy(req.WorkflowIDReusePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateFailureThresholds(req.RolloutStrategy); err != nil {
+		return nil, err
+	}
+	if err := validateApprovalPolicy(req.RolloutStrategy); err != nil {
+		return nil, err
+	}
+
+	state := newOrchestrationState(req)
+	state.reusePolicy = reusePolicy
+	state.listenForSignals(ctx)
+	if err := workflow.SetQueryHandler(ctx, QueryProgress, state.currentProgress); err != nil {
+		return nil, fmt.Errorf("failed to register progress query: %w", err)
+	}
+	state.notify(ctx, notify.Event{
+		Type:     notify.EventOrchestrationStarted,
+		Servers:  req.Servers,
+		Strategy: req.RolloutStrategy.Type,
+	})
+	defer state.flushNotifications(ctx)
+
 	result := &models.OrchestrationResult{
 		Results: make([]models.ExecutionResult, 0),
+		RetryOf: req.RetryOf,
 	}
 
-	var results []models.ExecutionResult
-	var err error
+	// The failure thresholds apply to the rollout as a whole, across
+	// dependency waves and canary phases
+	budget := newFailureBudget(req.RolloutStrategy, len(req.Servers))
 
-	switch req.RolloutStrategy.Type {
-	case "Parallel":
-		results, err = parallelExecution(ctx, req)
-	case "Rolling":
-		results, err = rollingExecution(ctx, req)
-	case "Sequential":
-		results, err = sequentialExecution(ctx, req)
-	default:
-		results, err = parallelExecution(ctx, req)
+	var results []models.ExecutionResult
+	if len(req.DependsOn) > 0 {
+		results, err = dependencyExecution(ctx, req, budget, state)
+	} else {
+		results, err = runStrategy(ctx, req, budget, state)
 	}
 
-	if err != nil {
-		return nil, err
+	// An abort that arrived while the last servers were running still counts
+	if err == nil {
+		err = state.abortError()
 	}
 
+	result.Approvals = state.approvals
+
 	// Count results
 	for _, r := range results {
 		result.Results = append(result.Results, r)
 		if r.Success {
 			result.ServersPatched++
+		} else if r.Cancelled {
+			result.ServersCancelled++
+		} else if r.Skipped {
+			result.ServersSkipped++
 		} else {
 			result.ServersFailed++
 		}
+		if r.Rollback != nil {
+			result.Rollbacks = append(result.Rollbacks, *r.Rollback)
+		}
 	}
 
 	result.Success = result.ServersFailed == 0
 
+	if state.abort != nil {
+		result.Success = false
+		result.Aborted = true
+		result.AbortReason = state.abort.Reason
+		result.AbortedBy = state.abort.Identity
+
+		if state.abort.Rollback {
+			state.setPhase(models.PhaseRollingBack)
+			logger.Warn("Orchestration aborted, rolling back completed servers", "reason", state.abort.Reason)
+			state.rollbackServers(ctx, req.Steps, results, state.abortError().Error())
+		} else {
+			logger.Warn("Orchestration aborted without rollback", "reason", state.abort.Reason)
+		}
+	}
+
+	result.Rollbacks = append(result.Rollbacks, state.rollbacks...)
+	for _, rollback := range result.Rollbacks {
+		if rollback.Success {
+			result.ServersRolledBack++
+		} else {
+			result.RollbackFailures++
+		}
+	}
+
+	state.recordAudit(ctx, req, result)
+	recordMetrics(ctx, req, result)
+
+	if err != nil {
+		// Attach the partial result so callers can see what happened before the failure
+		result.Success = false
+		result.Error = err.Error()
+		errType := "OrchestrationFailed"
+		state.setPhase(models.PhaseFailed)
+		if state.abort != nil {
+			errType = "OrchestrationAborted"
+			state.setPhase(models.PhaseAborted)
+		}
+		state.notify(ctx, notify.Event{Type: notify.EventOrchestrationCompleted, Error: result.Error, Result: notify.Summarize(result)})
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), errType, err, result)
+	}
+
+	state.setPhase(models.PhaseCompleted)
+	state.notify(ctx, notify.Event{Type: notify.EventOrchestrationCompleted, Result: notify.Summarize(result)})
 	logger.Info("Orchestration complete", "success", result.Success, "patched", result.ServersPatched, "failed", result.ServersFailed)
 
 	return result, nil
 }
 
-func parallelExecution(ctx workflow.Context, req models.ExecutionRequest) ([]models.ExecutionResult, error) {
+// runStrategy rolls the request's servers out with its rollout strategy,
+// recording each completed server in budget
+func runStrategy(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	switch req.RolloutStrategy.Type {
+	case "Parallel":
+		return parallelExecution(ctx, req, budget, state)
+	case "Rolling":
+		return rollingExecution(ctx, req, budget, state)
+	case "Sequential":
+		return sequentialExecution(ctx, req, budget, state)
+	case "Canary":
+		return canaryExecution(ctx, req, budget, state)
+	case "Topology":
+		return topologyExecution(ctx, req, budget, state)
+	default:
+		return parallelExecution(ctx, req, budget, state)
+	}
+}
+
+func parallelExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
 	logger := workflow.GetLogger(ctx)
-	logger.Info("Starting parallel execution", "servers", len(req.Servers))
+	logger.Info("Starting parallel execution", "servers", len(req.Servers), "maxConcurrency", req.RolloutStrategy.MaxConcurrency)
 
-	var futures []workflow.Future
+	results, err := runServers(ctx, req, req.Servers, req.RolloutStrategy.MaxConcurrency, budget, state)
+	if err != nil {
+		logger.Error("Failure threshold exceeded, triggering rollback", "error", err)
 
-	for _, serverID := range req.Servers {
-		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
-			WorkflowID: fmt.Sprintf("exec-%s", serverID),
-			TaskQueue:  serverID,
-		})
+		// Trigger rollback on all servers that were processed
+		state.rollbackServers(ctx, req.Steps, results, err.Error())
 
-		input := models.WorkflowInput{
-			ServerID: serverID,
-			Steps:    req.Steps,
-		}
-		future := workflow.ExecuteChildWorkflow(childCtx, ServerExecutionWorkflow, input)
-		futures = append(futures, future)
+		return results, err
 	}
 
-	var results []models.ExecutionResult
-	failures := 0
-	
-	for i, future := range futures {
-		var result models.ExecutionResult
-		if err := future.Get(ctx, &result); err != nil {
-			result = models.ExecutionResult{
-				ServerID: req.Servers[i],
-				Success:  false,
-				Error:    err.Error(),
+	return results, nil
+}
+
+// runServers executes the given servers with at most limit children in flight
+// (0 for no limit), starting the next server as soon as one finishes. Each
+// completion is recorded in the strategy's failure budget; once it is exceeded
+// no further servers are started, the children still running are cancelled and
+// an error is returned. An abort cancels the running children the same way,
+// leaving the abort error to the caller. Results are in the order of servers.
+func runServers(ctx workflow.Context, req models.ExecutionRequest, servers []string, limit int, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	if limit <= 0 || limit > len(servers) {
+		limit = len(servers)
+	}
+
+	completed := make([]*models.ExecutionResult, len(servers))
+	cancels := make([]workflow.CancelFunc, len(servers))
+	running := 0
+	next := 0
+	stopped := false
+	cancelled := false
+	var thresholdErr error
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(state.aborted, func(workflow.Future) {})
+
+	for {
+		for !stopped && running < limit && next < len(servers) {
+			if err := state.checkpoint(ctx); err != nil {
+				stopped = true
+				break
 			}
+
+			index := next
+			serverID := servers[index]
+			next++
+			running++
+
+			childCtx, cancel := workflow.WithCancel(ctx)
+			cancels[index] = cancel
+			selector.AddFuture(startServerExecution(childCtx, req, serverID, state), func(f workflow.Future) {
+				running--
+				cancels[index] = nil
+				result := awaitServerExecution(ctx, f, serverID, state)
+				completed[index] = &result
+				budget.record(result)
+			})
 		}
-		results = append(results, result)
-		
-		if !result.Success {
-			failures++
+
+		if running == 0 {
+			break
 		}
-	}
+		selector.Select(ctx)
 
-	// Check if max failures exceeded and trigger rollback
-	if req.RolloutStrategy.MaxFailures >= 0 && failures > req.RolloutStrategy.MaxFailures {
-		logger.Error("Max failures exceeded, triggering rollback", "failures", failures, "maxFailures", req.RolloutStrategy.MaxFailures)
-		
-		// Trigger rollback on all servers that were processed
-		for _, result := range results {
-			if result.Success {
-				logger.Info("Triggering rollback for server", "serverID", result.ServerID)
-				if err := triggerServerRollback(ctx, result.ServerID, req.Steps, result); err != nil {
-					logger.Error("Failed to trigger rollback", "serverID", result.ServerID, "error", err)
-				}
+		if cancelled {
+			continue
+		}
+		if thresholdErr = budget.exceeded(); thresholdErr != nil {
+			logger.Error("Failure threshold exceeded, cancelling running servers", "error", thresholdErr, "running", running, "notStarted", len(servers)-next)
+		} else if state.abort != nil {
+			logger.Warn("Orchestration aborted, cancelling running servers", "running", running, "notStarted", len(servers)-next)
+		} else {
+			continue
+		}
+		cancelled = true
+		stopped = true
+		for _, cancel := range cancels {
+			if cancel != nil {
+				cancel()
 			}
 		}
+	}
+
+	var results []models.ExecutionResult
+	for _, result := range completed {
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	return results, thresholdErr
+}
+
+func sequentialExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Starting sequential execution", "servers", len(req.Servers))
+
+	results, err := runServers(ctx, req, req.Servers, 1, budget, state)
+	if err != nil {
+		logger.Error("Failure threshold exceeded, triggering rollback", "error", err)
+		
+		// Trigger rollback on all successfully executed servers
+		state.rollbackServers(ctx, req.Steps, results, err.Error())
 		
-		return results, fmt.Errorf("exceeded max failures: %d > %d", failures, req.RolloutStrategy.MaxFailures)
+		return results, err
 	}
 
 	return results, nil
 }
 
-func sequentialExecution(ctx workflow.Context, req models.ExecutionRequest) ([]models.ExecutionResult, error) {
+func canaryExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
 	logger := workflow.GetLogger(ctx)
-	logger.Info("Starting sequential execution", "servers", len(req.Servers))
 
-	var results []models.ExecutionResult
-	failures := 0
+	percentage := req.RolloutStrategy.CanaryPercentage
+	if percentage <= 0 || percentage > 100 {
+		return nil, fmt.Errorf("invalid canary percentage: %d (must be between 1 and 100)", percentage)
+	}
 
-	for _, serverID := range req.Servers {
-		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
-			WorkflowID: fmt.Sprintf("exec-%s", serverID),
-			TaskQueue:  serverID,
-		})
+	followUp := req.RolloutStrategy.CanaryFollowUp
+	if followUp == "" {
+		followUp = "Rolling"
+	}
+	if followUp != "Rolling" && followUp != "Parallel" {
+		return nil, fmt.Errorf("invalid canary follow-up strategy: %s (must be Rolling or Parallel)", followUp)
+	}
 
-		input := models.WorkflowInput{
-			ServerID: serverID,
-			Steps:    req.Steps,
-		}
-		var result models.ExecutionResult
-		err := workflow.ExecuteChildWorkflow(childCtx, ServerExecutionWorkflow, input).Get(ctx, &result)
+	canaryCount := canarySize(len(req.Servers), percentage)
+	canaryServers := req.Servers[:canaryCount]
+	remainingServers := req.Servers[canaryCount:]
+
+	logger.Info("Starting canary execution", "servers", len(req.Servers), "canaries", canaryServers, "followUp", followUp)
+
+	// Execute canary group in parallel, tolerating no failures. parallelExecution
+	// rolls back the successful canaries if any of them fails.
+	canaryReq := req
+	canaryReq.Servers = canaryServers
+	canaryReq.RolloutStrategy.MaxFailures = 0
+	canaryReq.RolloutStrategy.MaxFailurePercent = 0
+	canaryReq.RolloutStrategy.FailureWindow = nil
+
+	state.setPhase(models.PhaseCanary)
+	results, err := parallelExecution(ctx, canaryReq, newFailureBudget(canaryReq.RolloutStrategy, len(canaryServers)), state)
+	if err != nil && state.abort == nil {
+		logger.Error("Canary group failed, remaining servers will not be touched", "error", err, "untouched", len(remainingServers))
+		return results, fmt.Errorf("canary failed: %w", err)
+	}
+	for _, result := range results {
+		budget.record(result)
+	}
 
-		if err != nil {
-			result = models.ExecutionResult{
-				ServerID: serverID,
-				Success:  false,
-				Error:    err.Error(),
-			}
+	if err != nil || len(remainingServers) == 0 {
+		return results, err
+	}
+
+	// Let the canaries bake before touching the rest of the fleet
+	if req.RolloutStrategy.CanaryBakeSeconds > 0 {
+		state.setPhase(models.PhaseBaking)
+		logger.Info("Baking canary group", "seconds", req.RolloutStrategy.CanaryBakeSeconds)
+		if err := state.sleep(ctx, time.Duration(req.RolloutStrategy.CanaryBakeSeconds)*time.Second); err != nil {
+			return results, err
 		}
+	}
+
+	logger.Info("Canary group succeeded, rolling out to remaining servers", "servers", len(remainingServers), "strategy", followUp)
 
-		results = append(results, result)
+	state.setPhase(models.PhaseRunning)
+	followUpReq := req
+	followUpReq.Servers = remainingServers
+	followUpReq.RolloutStrategy.Type = followUp
 
-		if !result.Success {
-			failures++
+	var followUpResults []models.ExecutionResult
+	if followUp == "Parallel" {
+		followUpResults, err = parallelExecution(ctx, followUpReq, budget, state)
+	} else {
+		followUpResults, err = rollingExecution(ctx, followUpReq, budget, state)
+	}
+	results = append(results, followUpResults...)
+
+	// The follow-up only rolls back its own servers when the failure threshold
+	// is exceeded, so roll the canaries back as well
+	if err != nil && state.abort == nil {
+		state.rollbackServers(ctx, req.Steps, results, err.Error())
+	}
+
+	return results, err
+}
+
+// canarySize returns the number of servers in the canary group, rounding up so
+// that at least one server is always used as a canary
+func canarySize(total int, percentage int) int {
+	size := (total*percentage + 99) / 100
+	if size < 1 {
+		size = 1
+	}
+	if size > total {
+		size = total
+	}
+	return size
+}
+
+// startServerExecution starts a ServerExecutionWorkflow child on the server's task queue
+func startServerExecution(ctx workflow.Context, req models.ExecutionRequest, serverID string, state *orchestrationState) workflow.Future {
+	childOptions := serverChildOptions(ctx, "exec", serverID, state)
+	// Wait for a cancelled server to compensate so its result reaches the orchestrator
+	childOptions.WaitForCancellation = true
+	childCtx := workflow.WithChildOptions(ctx, childOptions)
+
+	input := models.WorkflowInput{
+		ServerID: serverID,
+		Steps:    req.Steps,
+		Finally:  req.Finally,
+		Audit:    state.auditInfo(ctx),
+		Vars:     req.Vars,
+		Labels:   req.ServerLabels[serverID],
+	}
+
+	state.serverStarted(serverID, childOptions.WorkflowID)
+	return workflow.ExecuteChildWorkflow(childCtx, ServerExecutionWorkflow, input)
+}
+
+// serverChildOptions returns the options for a child workflow running on a
+// server's task queue. Child IDs are scoped to the current orchestration run as
+// <orchestration workflow ID>/<run ID>/<kind>-<server ID>, so the same server can
+// take part in concurrent and repeated orchestrations and every run's children
+// can be found from the parent.
+func serverChildOptions(ctx workflow.Context, kind string, serverID string, state *orchestrationState) workflow.ChildWorkflowOptions {
+	parent := workflow.GetInfo(ctx).WorkflowExecution
+	return workflow.ChildWorkflowOptions{
+		WorkflowID:            fmt.Sprintf("%s/%s/%s-%s", parent.ID, parent.RunID, kind, serverID),
+		TaskQueue:             serverID,
+		WorkflowIDReusePolicy: state.reusePolicy,
+	}
+}
+
+// parseWorkflowIDReusePolicy maps the reuse policy name in an ExecutionRequest to
+// the Temporal enum, defaulting to AllowDuplicateFailedOnly
+func parseWorkflowIDReusePolicy(policy string) (enumspb.WorkflowIdReusePolicy, error) {
+	switch policy {
+	case "", "AllowDuplicateFailedOnly":
+		return enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY, nil
+	case "AllowDuplicate":
+		return enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE, nil
+	case "RejectDuplicate":
+		return enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE, nil
+	default:
+		return enumspb.WORKFLOW_ID_REUSE_POLICY_UNSPECIFIED, fmt.Errorf("invalid workflow ID reuse policy: %s", policy)
+	}
+}
+
+// awaitServerExecution waits for a server's child workflow, turning a workflow
+// error into a failed result
+func awaitServerExecution(ctx workflow.Context, future workflow.Future, serverID string, state *orchestrationState) models.ExecutionResult {
+	var result models.ExecutionResult
+	if err := future.Get(ctx, &result); err != nil {
+		result = executionResultFromError(serverID, err)
+	}
+	summarizeOutputs(&result)
+
+	state.serverCompleted(result)
+	if !result.Success && !result.Cancelled {
+		state.notify(ctx, notify.Event{Type: notify.EventServerFailed, ServerID: serverID, Error: result.Error})
+	}
+	return result
+}
+
+// executionResultFromError recovers the ExecutionResult a failed or cancelled
+// server attaches to its error, falling back to a result holding only the error
+func executionResultFromError(serverID string, err error) models.ExecutionResult {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.HasDetails() {
+		var result models.ExecutionResult
+		if appErr.Details(&result) == nil {
+			result.Success = false
+			return result
 		}
+	}
 
-		// Check if max failures exceeded
-		if req.RolloutStrategy.MaxFailures >= 0 && failures > req.RolloutStrategy.MaxFailures {
-			logger.Error("Max failures exceeded, triggering rollback", "failures", failures, "maxFailures", req.RolloutStrategy.MaxFailures)
-			
-			// Trigger rollback on all successfully executed servers
-			for _, prevResult := range results {
-				if prevResult.Success {
-					logger.Info("Triggering rollback for server", "serverID", prevResult.ServerID)
-					if err := triggerServerRollback(ctx, prevResult.ServerID, req.Steps, prevResult); err != nil {
-						logger.Error("Failed to trigger rollback", "serverID", prevResult.ServerID, "error", err)
-					}
+	var canceledErr *temporal.CanceledError
+	if errors.As(err, &canceledErr) {
+		var result models.ExecutionResult
+		if !canceledErr.HasDetails() || canceledErr.Details(&result) != nil {
+			result = models.ExecutionResult{ServerID: serverID, Error: err.Error()}
+		}
+		result.Success = false
+		result.Cancelled = true
+		return result
+	}
+
+	return models.ExecutionResult{
+		ServerID: serverID,
+		Success:  false,
+		Error:    err.Error(),
+	}
+}
+
+// largeOutputs are the step outputs left out of the orchestration result
+var largeOutputs = []string{"stdout", "stderr"}
+
+// summarizeOutputs drops the stdout and stderr of script steps from a server's
+// result, keeping a digest of the full outputs. The orchestration result holds
+// every server's result and would outgrow Temporal's payload size limit on a
+// large fleet. The full outputs stay in the server's own workflow result.
+func summarizeOutputs(result *models.ExecutionResult) {
+	summarize := func(steps []models.StepResult) {
+		for i, step := range steps {
+			var summary map[string]interface{}
+			for key, value := range step.Metadata {
+				if slices.Contains(largeOutputs, key) {
+					continue
 				}
+				if summary == nil {
+					summary = make(map[string]interface{}, len(step.Metadata))
+				}
+				summary[key] = value
+			}
+			if len(summary) == len(step.Metadata) {
+				continue
 			}
-			
-			return results, fmt.Errorf("exceeded max failures: %d > %d", failures, req.RolloutStrategy.MaxFailures)
+			steps[i].OutputDigest = audit.Digest(step.Metadata)
+			steps[i].Metadata = summary
 		}
 	}
+	summarize(result.StepsExecuted)
+	summarize(result.Finally)
+}
 
-	return results, nil
+// rollbackServers triggers a rollback on every server that completed successfully
+// and has not been rolled back yet, recording the outcome of each
+func (s *orchestrationState) rollbackServers(ctx workflow.Context, steps []models.StepDefinition, results []models.ExecutionResult, reason string) {
+	logger := workflow.GetLogger(ctx)
+	s.setPhase(models.PhaseRollingBack)
+
+	for _, result := range results {
+		if result.Success && !s.rolledBack[result.ServerID] {
+			s.rolledBack[result.ServerID] = true
+			logger.Info("Triggering rollback for server", "serverID", result.ServerID)
+			rollback, err := triggerServerRollback(ctx, result.ServerID, steps, result, s)
+			if err != nil {
+				logger.Error("Failed to trigger rollback", "serverID", result.ServerID, "error", err)
+			}
+			rollback.Reason = reason
+			s.rollbacks = append(s.rollbacks, rollback)
+			s.serverRolledBack(result.ServerID, err)
+		}
+	}
 }
 
-func triggerServerRollback(ctx workflow.Context, serverID string, steps []models.StepDefinition, executionResult models.ExecutionResult) error {
+func triggerServerRollback(ctx workflow.Context, serverID string, steps []models.StepDefinition, executionResult models.ExecutionResult, state *orchestrationState) (models.RollbackResult, error) {
 	logger := workflow.GetLogger(ctx)
+	startedAt := workflow.Now(ctx)
 	
-	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
-		WorkflowID: fmt.Sprintf("rollback-%s", serverID),
-		TaskQueue:  serverID,
-	})
+	childCtx := workflow.WithChildOptions(ctx, serverChildOptions(ctx, "rollback", serverID, state))
+	state.notify(ctx, notify.Event{Type: notify.EventRollbackStarted, ServerID: serverID})
 	
-	// Build executed steps info from the execution result
+	// Build executed steps info from the execution result, carrying the metadata
+	// each handler captured so that it reaches Rollback. Results are in completion
+	// order, so each is matched to the first unused step definition of its name.
 	var executedSteps []ExecutedStepInfo
-	for i, stepResult := range executionResult.StepsExecuted {
-		if stepResult.Success && i < len(steps) {
-			executedSteps = append(executedSteps, ExecutedStepInfo{
-				Step:     steps[i],
-				Metadata: nil,
-			})
+	used := make([]bool, len(steps))
+	for _, stepResult := range executionResult.StepsExecuted {
+		for i, step := range steps {
+			if used[i] || step.Name != stepResult.Name {
+				continue
+			}
+			used[i] = true
+			if stepResult.Params != nil {
+				step.Params = stepResult.Params
+			}
+			if stepResult.Success {
+				executedSteps = append(executedSteps, ExecutedStepInfo{
+					Step:     step,
+					Metadata: stepResult.Metadata,
+				})
+			}
+			break
 		}
 	}
 	
@@ -201,19 +574,35 @@ func triggerServerRollback(ctx workflow.Context, serverID string, steps []models
 	input := RollbackWorkflowInput{
 		ServerID:      serverID,
 		ExecutedSteps: executedSteps,
+		Audit:         state.auditInfo(ctx),
 	}
 	
-	err := workflow.ExecuteChildWorkflow(childCtx, ServerRollbackWorkflow, input).Get(ctx, nil)
+	var rollback models.RollbackResult
+	err := workflow.ExecuteChildWorkflow(childCtx, ServerRollbackWorkflow, input).Get(ctx, &rollback)
 	if err != nil {
 		logger.Error("Rollback workflow failed", "serverID", serverID, "error", err)
-		return err
+		
+		// A rollback that ran but failed on some steps attaches its result
+		var appErr *temporal.ApplicationError
+		if !errors.As(err, &appErr) || !appErr.HasDetails() || appErr.Details(&rollback) != nil {
+			rollback = models.RollbackResult{
+				ServerID:    serverID,
+				Steps:       []models.StepRollbackResult{},
+				StartedAt:   startedAt,
+				CompletedAt: workflow.Now(ctx),
+			}
+		}
+		rollback.Success = false
+		rollback.Error = err.Error()
+		state.notify(ctx, notify.Event{Type: notify.EventRollbackFailed, ServerID: serverID, Error: rollback.Error})
+		return rollback, err
 	}
 	
 	logger.Info("Rollback workflow completed", "serverID", serverID)
-	return nil
+	return rollback, nil
 }
 
-func rollingExecution(ctx workflow.Context, req models.ExecutionRequest) ([]models.ExecutionResult, error) {
+func rollingExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
 	logger := workflow.GetLogger(ctx)
 
 	batchSize := req.RolloutStrategy.BatchSize
@@ -223,72 +612,66 @@ func rollingExecution(ctx workflow.Context, req models.ExecutionRequest) ([]mode
 
 	logger.Info("Starting rolling execution", "servers", len(req.Servers), "batchSize", batchSize)
 
-	var allResults []models.ExecutionResult
-	failures := 0
-
+	var batches [][]string
 	for i := 0; i < len(req.Servers); i += batchSize {
 		end := i + batchSize
 		if end > len(req.Servers) {
 			end = len(req.Servers)
 		}
-		batch := req.Servers[i:end]
+		batches = append(batches, req.Servers[i:end])
+	}
 
-		logger.Info("Processing batch", "batch", (i/batchSize)+1, "servers", batch)
+	return batchedExecution(ctx, req, batches, budget, state)
+}
 
-		// Execute batch in parallel
-		var futures []workflow.Future
-		for _, serverID := range batch {
-			childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
-				WorkflowID: fmt.Sprintf("exec-%s", serverID),
-				TaskQueue:  serverID,
-			})
+// batchedExecution runs the batches one after another, each in parallel, with
+// the approval gates and delays of the rollout strategy between them
+func batchedExecution(ctx workflow.Context, req models.ExecutionRequest, batches [][]string, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	logger := workflow.GetLogger(ctx)
 
-			input := models.WorkflowInput{
-				ServerID: serverID,
-				Steps:    req.Steps,
-			}
-			future := workflow.ExecuteChildWorkflow(childCtx, ServerExecutionWorkflow, input)
-			futures = append(futures, future)
+	var allResults []models.ExecutionResult
+
+	for i, batch := range batches {
+		if err := state.checkpoint(ctx); err != nil {
+			return allResults, err
 		}
 
-		// Wait for batch
-		for j, future := range futures {
-			var result models.ExecutionResult
-			if err := future.Get(ctx, &result); err != nil {
-				result = models.ExecutionResult{
-					ServerID: batch[j],
-					Success:  false,
-					Error:    err.Error(),
-				}
-			}
+		batchNumber := i + 1
+		last := batchNumber == len(batches)
+		state.batchStarted(batchNumber, len(batches))
+		logger.Info("Processing batch", "batch", batchNumber, "servers", batch)
+
+		// Execute batch in parallel, stopping it as soon as the failure threshold is crossed
+		batchResults, err := runServers(ctx, req, batch, len(batch), budget, state)
+		allResults = append(allResults, batchResults...)
+		state.notify(ctx, notify.Event{
+			Type:         notify.EventBatchCompleted,
+			Servers:      batch,
+			Batch:        batchNumber,
+			TotalBatches: len(batches),
+		})
 
-			allResults = append(allResults, result)
+		if err != nil {
+			logger.Error("Failure threshold exceeded, triggering rollback", "error", err)
 
-			if !result.Success {
-				failures++
-			}
+			// Trigger rollback on all successfully executed servers
+			state.rollbackServers(ctx, req.Steps, allResults, err.Error())
+
+			return allResults, err
 		}
 
-		// Check failure threshold
-		if req.RolloutStrategy.MaxFailures >= 0 && failures > req.RolloutStrategy.MaxFailures {
-			logger.Error("Max failures exceeded, triggering rollback", "failures", failures, "maxFailures", req.RolloutStrategy.MaxFailures)
-			
-			// Trigger rollback on all successfully executed servers
-			for _, result := range allResults {
-				if result.Success {
-					logger.Info("Triggering rollback for server", "serverID", result.ServerID)
-					if err := triggerServerRollback(ctx, result.ServerID, req.Steps, result); err != nil {
-						logger.Error("Failed to trigger rollback", "serverID", result.ServerID, "error", err)
-					}
-				}
+		// Wait for a human to approve continuing past this batch
+		if !last && approvalRequired(req.RolloutStrategy.Approval, batchNumber) {
+			if err := state.awaitApproval(ctx, req.RolloutStrategy.Approval, batchNumber); err != nil {
+				return allResults, err
 			}
-			
-			return allResults, fmt.Errorf("exceeded max failures: %d > %d", failures, req.RolloutStrategy.MaxFailures)
 		}
 
 		// Delay between batches
-		if end < len(req.Servers) && req.RolloutStrategy.BatchDelaySeconds > 0 {
-			workflow.Sleep(ctx, time.Duration(req.RolloutStrategy.BatchDelaySeconds)*time.Second)
+		if !last && req.RolloutStrategy.BatchDelaySeconds > 0 {
+			if err := state.sleep(ctx, time.Duration(req.RolloutStrategy.BatchDelaySeconds)*time.Second); err != nil {
+				return allResults, err
+			}
 		}
 	}
 
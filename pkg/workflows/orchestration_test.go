@@ -0,0 +1,1123 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.temporal.io/sdk/activity"
+	temporalotel "go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+
+	"github.com/melslow/kitsune/pkg/audit"
+	"github.com/melslow/kitsune/pkg/metrics"
+	"github.com/melslow/kitsune/pkg/models"
+	"github.com/melslow/kitsune/pkg/notify"
+)
+
+// fakeStepActivities stands in for the local worker activities and records
+// which servers executed and rolled back steps
+type fakeStepActivities struct {
+	mu               sync.Mutex
+	failOn           map[string]bool // server IDs
+	failSteps        map[string]bool // step names
+	failRollbackOn   map[string]bool // server IDs
+	executed         []string
+	rolledBack       []string
+	rollbackMetadata []map[string]interface{}
+	events           []notify.Event
+	audits           []audit.Record
+	auditInfo        []models.AuditInfo // passed to ExecuteStep
+}
+
+func (f *fakeStepActivities) Notify(ctx context.Context, event notify.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeStepActivities) RecordAudit(ctx context.Context, records []audit.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audits = append(f.audits, records...)
+	return nil
+}
+
+func (f *fakeStepActivities) ExecuteStep(ctx context.Context, serverID string, step models.StepDefinition, auditInfo models.AuditInfo) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.executed = append(f.executed, serverID)
+	f.auditInfo = append(f.auditInfo, auditInfo)
+	if f.failOn[serverID] || f.failSteps[step.Name] {
+		return nil, fmt.Errorf("step %s failed on %s", step.Name, serverID)
+	}
+	return map[string]interface{}{"previous_version": "1.0-" + serverID}, nil
+}
+
+func (f *fakeStepActivities) RollbackStep(ctx context.Context, serverID string, step models.StepDefinition, metadata map[string]interface{}, auditInfo models.AuditInfo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rolledBack = append(f.rolledBack, serverID+"/"+step.Name)
+	f.rollbackMetadata = append(f.rollbackMetadata, metadata)
+	if f.failRollbackOn[serverID] {
+		return fmt.Errorf("rollback of %s failed on %s", step.Name, serverID)
+	}
+	return nil
+}
+
+func newTestEnv(fake *fakeStepActivities) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	return newTestEnvWithSuite(&suite, fake)
+}
+
+func newTestEnvWithSuite(suite *testsuite.WorkflowTestSuite, fake *fakeStepActivities) *testsuite.TestWorkflowEnvironment {
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(OrchestrationWorkflow)
+	env.RegisterWorkflow(ServerExecutionWorkflow)
+	env.RegisterWorkflow(ServerRollbackWorkflow)
+	env.RegisterActivityWithOptions(fake.ExecuteStep, activity.RegisterOptions{Name: "ExecuteStep"})
+	env.RegisterActivityWithOptions(fake.RollbackStep, activity.RegisterOptions{Name: "RollbackStep"})
+	env.RegisterActivityWithOptions(fake.Notify, activity.RegisterOptions{Name: "Notify"})
+	env.RegisterActivityWithOptions(fake.RecordAudit, activity.RegisterOptions{Name: "RecordAudit"})
+	return env
+}
+
+func echoSteps() []models.StepDefinition {
+	return []models.StepDefinition{
+		{
+			Name:     "hello",
+			Type:     "echo",
+			Params:   map[string]interface{}{"message": "hello"},
+			Required: true,
+		},
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeStepActivities) executedCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.executed)
+}
+
+// failedResult extracts the partial OrchestrationResult attached to a workflow error
+func failedResult(t *testing.T, err error) models.OrchestrationResult {
+	t.Helper()
+
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("Expected application error, got: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := appErr.Details(&result); err != nil {
+		t.Fatalf("Failed to decode result from error details: %v", err)
+	}
+	return result
+}
+
+func TestCanarySize(t *testing.T) {
+	tests := []struct {
+		total      int
+		percentage int
+		expected   int
+	}{
+		{total: 10, percentage: 10, expected: 1},
+		{total: 10, percentage: 25, expected: 3},
+		{total: 3, percentage: 1, expected: 1},
+		{total: 4, percentage: 100, expected: 4},
+	}
+
+	for _, tt := range tests {
+		if got := canarySize(tt.total, tt.percentage); got != tt.expected {
+			t.Errorf("canarySize(%d, %d) = %d, expected %d", tt.total, tt.percentage, got, tt.expected)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_CanarySuccess(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:              "Canary",
+			CanaryPercentage:  25,
+			CanaryBakeSeconds: 60,
+			CanaryFollowUp:    "Parallel",
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	if !result.Success || result.ServersPatched != 4 {
+		t.Errorf("Expected 4 patched servers, got: %+v", result)
+	}
+
+	if fake.executed[0] != "server-1" {
+		t.Errorf("Expected server-1 to run first as the canary, got: %v", fake.executed)
+	}
+}
+
+func TestOrchestrationWorkflow_CanaryFailureLeavesRemainingUntouched(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4", "server-5"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:             "Canary",
+			CanaryPercentage: 40,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected canary failure error")
+	}
+
+	for _, serverID := range []string{"server-3", "server-4", "server-5"} {
+		if contains(fake.executed, serverID) {
+			t.Errorf("Expected %s to be untouched, executed: %v", serverID, fake.executed)
+		}
+	}
+
+	if !contains(fake.rolledBack, "server-1/hello") {
+		t.Errorf("Expected canary server-1 to be rolled back, rolled back: %v", fake.rolledBack)
+	}
+}
+
+func TestOrchestrationWorkflow_CanaryFollowUpFailureRollsBackCanaries(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-3": true, "server-4": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:             "Canary",
+			CanaryPercentage: 25,
+			BatchSize:        3,
+			MaxFailures:      1,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected failure threshold error")
+	}
+
+	for _, serverID := range []string{"server-1", "server-2"} {
+		if !contains(fake.rolledBack, serverID+"/hello") {
+			t.Errorf("Expected %s to be rolled back, rolled back: %v", serverID, fake.rolledBack)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_AbortWithRollback(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:              "Rolling",
+			BatchSize:         1,
+			BatchDelaySeconds: 60,
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAbort, models.AbortRequest{
+			Reason:   "bad patch",
+			Identity: "oncall",
+			Rollback: true,
+		})
+	}, 30*time.Second)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if !result.Aborted || result.AbortReason != "bad patch" || result.AbortedBy != "oncall" {
+		t.Errorf("Expected abort to be recorded, got: %+v", result)
+	}
+
+	if contains(fake.executed, "server-2") || contains(fake.executed, "server-3") {
+		t.Errorf("Expected no servers to run after abort, executed: %v", fake.executed)
+	}
+
+	if !contains(fake.rolledBack, "server-1/hello") {
+		t.Errorf("Expected server-1 to be rolled back, rolled back: %v", fake.rolledBack)
+	}
+}
+
+func TestOrchestrationWorkflow_AbortCancelsRunningServers(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+	slowStep(env, fake, "server-2", "second")
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2"},
+		Steps:   twoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type: "Parallel",
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAbort, models.AbortRequest{Reason: "bad patch", Identity: "oncall", Rollback: true})
+	}, 10*time.Minute)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if !result.Aborted || result.ServersPatched != 1 || result.ServersCancelled != 1 {
+		t.Errorf("Expected an aborted result with 1 patched and 1 cancelled server, got: %+v", result)
+	}
+
+	// server-2's second step is cancelled while in flight rather than waited for
+	if count := strings.Count(strings.Join(fake.executed, ","), "server-2"); count != 1 {
+		t.Errorf("Expected server-2 to complete only its first step, ran %d: %v", count, fake.executed)
+	}
+	if !contains(fake.rolledBack, "server-1/second") || !contains(fake.rolledBack, "server-2/first") {
+		t.Errorf("Expected both servers to be rolled back, got %v", fake.rolledBack)
+	}
+}
+
+func TestOrchestrationWorkflow_AbortWithoutRollback(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:              "Rolling",
+			BatchSize:         1,
+			BatchDelaySeconds: 60,
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAbort, models.AbortRequest{Reason: "maintenance window closed"})
+	}, 30*time.Second)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if !result.Aborted || result.ServersPatched != 1 {
+		t.Errorf("Expected aborted result with one patched server, got: %+v", result)
+	}
+
+	if len(fake.rolledBack) != 0 {
+		t.Errorf("Expected no rollback, rolled back: %v", fake.rolledBack)
+	}
+}
+
+func TestOrchestrationWorkflow_PauseAndResume(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type: "Sequential",
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalPause, nil)
+	}, 0)
+	env.RegisterDelayedCallback(func() {
+		// The first server was already running when the pause arrived
+		if count := fake.executedCount(); count != 1 {
+			t.Errorf("Expected only the first server to run while paused, executed: %d", count)
+		}
+		env.SignalWorkflow(SignalResume, nil)
+	}, time.Hour)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if fake.executedCount() != 3 {
+		t.Errorf("Expected all servers to run after resume, executed: %v", fake.executed)
+	}
+}
+
+func TestOrchestrationWorkflow_ProgressQuery(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:              "Rolling",
+			BatchSize:         1,
+			BatchDelaySeconds: 60,
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(QueryProgress)
+		if err != nil {
+			t.Fatalf("Failed to query progress: %v", err)
+		}
+
+		var progress models.OrchestrationProgress
+		if err := value.Get(&progress); err != nil {
+			t.Fatalf("Failed to decode progress: %v", err)
+		}
+
+		if progress.Phase != models.PhaseRunning || progress.CurrentBatch != 1 || progress.TotalBatches != 3 {
+			t.Errorf("Unexpected progress: %+v", progress)
+		}
+
+		expected := []string{models.StatusSucceeded, models.StatusPending, models.StatusPending}
+		for i, server := range progress.Servers {
+			if server.Status != expected[i] {
+				t.Errorf("Expected %s to be %s, got %s", server.ServerID, expected[i], server.Status)
+			}
+		}
+	}, 30*time.Second)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	value, err := env.QueryWorkflow(QueryProgress)
+	if err != nil {
+		t.Fatalf("Failed to query progress: %v", err)
+	}
+
+	var progress models.OrchestrationProgress
+	if err := value.Get(&progress); err != nil {
+		t.Fatalf("Failed to decode progress: %v", err)
+	}
+
+	if progress.Phase != models.PhaseCompleted {
+		t.Errorf("Expected completed phase, got: %s", progress.Phase)
+	}
+
+	// Child IDs are scoped to the orchestration run
+	for _, server := range progress.Servers {
+		if !strings.HasSuffix(server.WorkflowID, "/exec-"+server.ServerID) || strings.Count(server.WorkflowID, "/") != 2 {
+			t.Errorf("Expected run-scoped child workflow ID for %s, got: %s", server.ServerID, server.WorkflowID)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_InvalidReusePolicy(t *testing.T) {
+	env := newTestEnv(&fakeStepActivities{})
+
+	req := models.ExecutionRequest{
+		Servers:               []string{"server-1"},
+		Steps:                 echoSteps(),
+		RolloutStrategy:       models.RolloutStrategy{Type: "Parallel"},
+		WorkflowIDReusePolicy: "TerminateEverything",
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	err := env.GetWorkflowError()
+	if err == nil || !strings.Contains(err.Error(), "invalid workflow ID reuse policy") {
+		t.Errorf("Expected invalid reuse policy error, got: %v", err)
+	}
+}
+
+func TestOrchestrationWorkflow_ApprovalGate(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:      "Rolling",
+			BatchSize: 1,
+			Approval: &models.ApprovalPolicy{
+				AfterBatches: []int{1},
+			},
+		},
+	}
+
+	env.RegisterDelayedCallback(func() {
+		if count := fake.executedCount(); count != 1 {
+			t.Errorf("Expected rollout to wait at the gate after batch 1, executed: %d", count)
+		}
+		env.SignalWorkflow(SignalApprove, models.ApprovalRequest{Identity: "alice", Comment: "looks good"})
+	}, time.Hour)
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	if len(result.Approvals) != 1 {
+		t.Fatalf("Expected one approval record, got: %+v", result.Approvals)
+	}
+
+	approval := result.Approvals[0]
+	if approval.Batch != 1 || !approval.Approved || approval.ApprovedBy != "alice" {
+		t.Errorf("Unexpected approval record: %+v", approval)
+	}
+}
+
+func TestOrchestrationWorkflow_ApprovalTimeoutAborts(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:      "Rolling",
+			BatchSize: 1,
+			Approval: &models.ApprovalPolicy{
+				EveryBatch:     true,
+				TimeoutSeconds: 600,
+			},
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if !result.Aborted || len(result.Approvals) != 1 || !result.Approvals[0].TimedOut {
+		t.Errorf("Expected timed out gate to abort, got: %+v", result)
+	}
+
+	if contains(fake.executed, "server-2") {
+		t.Errorf("Expected server-2 to be untouched, executed: %v", fake.executed)
+	}
+}
+
+func TestOrchestrationWorkflow_InvalidApprovalPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy models.RolloutStrategy
+	}{
+		{name: "unknown onTimeout", strategy: models.RolloutStrategy{Type: "Rolling", Approval: &models.ApprovalPolicy{EveryBatch: true, OnTimeout: "Proceed"}}},
+		{name: "parallel", strategy: models.RolloutStrategy{Type: "Parallel", Approval: &models.ApprovalPolicy{EveryBatch: true}}},
+		{name: "sequential", strategy: models.RolloutStrategy{Type: "Sequential", Approval: &models.ApprovalPolicy{EveryBatch: true}}},
+		{name: "canary with parallel follow-up", strategy: models.RolloutStrategy{Type: "Canary", CanaryPercentage: 50, CanaryFollowUp: "Parallel", Approval: &models.ApprovalPolicy{EveryBatch: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeStepActivities{}
+			env := newTestEnv(fake)
+
+			req := models.ExecutionRequest{
+				Servers:         []string{"server-1", "server-2"},
+				Steps:           echoSteps(),
+				RolloutStrategy: tt.strategy,
+			}
+			env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+			if err := env.GetWorkflowError(); err == nil {
+				t.Error("Expected the approval policy to be rejected")
+			}
+			if count := fake.executedCount(); count != 0 {
+				t.Errorf("Expected no servers to run, executed: %v", fake.executed)
+			}
+		})
+	}
+}
+
+func TestOrchestrationWorkflow_RetryFailedServers(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	original := models.OrchestrationRecord{
+		Ref: models.OrchestrationRef{WorkflowID: "patch-1", RunID: "run-1"},
+		Request: models.ExecutionRequest{
+			Servers:         []string{"server-1", "server-2", "server-3", "server-4"},
+			Steps:           echoSteps(),
+			RolloutStrategy: models.RolloutStrategy{Type: "Sequential"},
+			Requester:       "alice",
+		},
+		Result: models.OrchestrationResult{
+			Results: []models.ExecutionResult{
+				{ServerID: "server-1", Success: true},
+				{ServerID: "server-2", Success: false, Error: "boom"},
+				{ServerID: "server-3", Success: true},
+			},
+			Rollbacks: []models.RollbackResult{
+				{ServerID: "server-3", Success: true},
+			},
+		},
+	}
+	env.RegisterActivityWithOptions(func(ctx context.Context, ref models.OrchestrationRef) (*models.OrchestrationRecord, error) {
+		if ref.WorkflowID != "patch-1" {
+			return nil, fmt.Errorf("unexpected orchestration: %s", ref.WorkflowID)
+		}
+		return &original, nil
+	}, activity.RegisterOptions{Name: "LoadOrchestration"})
+
+	env.ExecuteWorkflow(OrchestrationWorkflow, models.ExecutionRequest{
+		RetryOf:   &models.OrchestrationRef{WorkflowID: "patch-1"},
+		Requester: "bob",
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+
+	expected := []string{"server-2", "server-3", "server-4"}
+	if strings.Join(fake.executed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected only %v to run, executed: %v", expected, fake.executed)
+	}
+
+	if result.RetryOf == nil || result.RetryOf.WorkflowID != "patch-1" || result.RetryOf.RunID != "run-1" {
+		t.Errorf("Expected result to link back to the original run, got: %+v", result.RetryOf)
+	}
+
+	// The retried steps are attributed to the person who requested the retry
+	if len(fake.auditInfo) != len(expected) {
+		t.Fatalf("Expected audit info for %d steps, got %+v", len(expected), fake.auditInfo)
+	}
+	for _, info := range fake.auditInfo {
+		if info.Requester != "bob" {
+			t.Errorf("Expected retried steps to be audited as run by bob, got %+v", info)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_RollbackReceivesStepMetadata(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"server-1", "server-2"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Sequential"},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if got := result.Results[0].StepsExecuted[0].Metadata["previous_version"]; got != "1.0-server-1" {
+		t.Errorf("Expected step metadata in the execution result, got: %v", got)
+	}
+
+	if len(fake.rollbackMetadata) != 1 || fake.rollbackMetadata[0]["previous_version"] != "1.0-server-1" {
+		t.Errorf("Expected rollback to receive the step metadata, got: %v", fake.rollbackMetadata)
+	}
+}
+
+func TestOrchestrationWorkflow_ReportsRollbackOutcomes(t *testing.T) {
+	fake := &fakeStepActivities{
+		failOn:         map[string]bool{"server-3": true},
+		failRollbackOn: map[string]bool{"server-2": true},
+	}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"server-1", "server-2", "server-3"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Sequential"},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	result := failedResult(t, env.GetWorkflowError())
+
+	if result.ServersRolledBack != 1 || result.RollbackFailures != 1 || len(result.Rollbacks) != 2 {
+		t.Fatalf("Expected one successful and one failed rollback, got: %+v", result.Rollbacks)
+	}
+
+	for _, rollback := range result.Rollbacks {
+		if !strings.Contains(rollback.Reason, "exceeded max failures") {
+			t.Errorf("Expected max failures reason, got: %s", rollback.Reason)
+		}
+		if len(rollback.Steps) != 1 || rollback.Steps[0].Name != "hello" {
+			t.Errorf("Expected per-step outcome for %s, got: %+v", rollback.ServerID, rollback.Steps)
+		}
+		if rollback.ServerID == "server-2" && (rollback.Success || rollback.Steps[0].Error == "") {
+			t.Errorf("Expected server-2 rollback to fail, got: %+v", rollback)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_LargeFleetResultLeavesOutScriptOutput(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	outputs := map[string]interface{}{
+		"exit_code":        0,
+		"stdout":           strings.Repeat("o", 4096),
+		"stderr":           strings.Repeat("e", 4096),
+		"stdout_truncated": true,
+		"stderr_truncated": true,
+	}
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(outputs, nil)
+
+	servers := make([]string, 300)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("server-%d", i+1)
+	}
+	req := models.ExecutionRequest{
+		Servers:         servers,
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Parallel"},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to encode result: %v", err)
+	}
+	if len(data) > 512*1024 {
+		t.Errorf("Expected the result of 300 servers to stay small, got %d bytes", len(data))
+	}
+
+	step := result.Results[0].StepsExecuted[0]
+	if _, ok := step.Metadata["stdout"]; ok {
+		t.Errorf("Expected stdout to be left out of the orchestration result, got %v", step.Metadata)
+	}
+	if step.Metadata["exit_code"] != float64(0) || step.Metadata["stdout_truncated"] != true {
+		t.Errorf("Expected the exit code and truncation flags to be kept, got %v", step.Metadata)
+	}
+	if step.OutputDigest == "" || fake.audits[0].OutputDigest != step.OutputDigest {
+		t.Errorf("Expected the digest of the full outputs to be audited, got %q and %q", step.OutputDigest, fake.audits[0].OutputDigest)
+	}
+}
+
+func TestOrchestrationWorkflow_ParallelMaxConcurrency(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4", "server-5"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:           "Parallel",
+			MaxConcurrency: 2,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	if result.ServersPatched != len(req.Servers) {
+		t.Errorf("Expected %d servers patched, got %d", len(req.Servers), result.ServersPatched)
+	}
+	// Results are reported in request order regardless of completion order
+	for i, r := range result.Results {
+		if r.ServerID != req.Servers[i] {
+			t.Errorf("Expected result %d for %s, got %s", i, req.Servers[i], r.ServerID)
+		}
+	}
+}
+
+func TestOrchestrationWorkflow_ParallelMaxConcurrencyStopsOnMaxFailures(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:           "Parallel",
+			MaxConcurrency: 1,
+			MaxFailures:    0,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected workflow to fail once max failures was exceeded")
+	}
+
+	if !contains(fake.executed, "server-1") || !contains(fake.executed, "server-2") {
+		t.Errorf("Expected server-1 and server-2 to start, got %v", fake.executed)
+	}
+	if contains(fake.executed, "server-3") || contains(fake.executed, "server-4") {
+		t.Errorf("Expected remaining servers to be left untouched, got %v", fake.executed)
+	}
+
+	result := failedResult(t, err)
+	if len(result.Results) != 2 {
+		t.Errorf("Expected 2 results, got %d", len(result.Results))
+	}
+	if !contains(fake.rolledBack, "server-1/hello") {
+		t.Errorf("Expected server-1 to be rolled back, got %v", fake.rolledBack)
+	}
+}
+
+// slowStep makes the named step on serverID take an hour of workflow time, so
+// other servers finish while it is still running
+func slowStep(env *testsuite.TestWorkflowEnvironment, fake *fakeStepActivities, serverID string, stepName string) {
+	named := mock.MatchedBy(func(step models.StepDefinition) bool { return step.Name == stepName })
+	env.OnActivity("ExecuteStep", mock.Anything, serverID, named, mock.Anything).After(time.Hour).Return(fake.ExecuteStep)
+	env.OnActivity("ExecuteStep", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fake.ExecuteStep)
+}
+
+func twoSteps() []models.StepDefinition {
+	return []models.StepDefinition{
+		{Name: "first", Type: "echo", Params: map[string]interface{}{"message": "first"}, Required: true},
+		{Name: "second", Type: "echo", Params: map[string]interface{}{"message": "second"}, Required: true},
+	}
+}
+
+func TestOrchestrationWorkflow_ParallelCancelsRunningServersOnMaxFailures(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-1": true}}
+	env := newTestEnv(fake)
+	slowStep(env, fake, "server-2", "second")
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2"},
+		Steps:   twoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type: "Parallel",
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected workflow to fail once max failures was exceeded")
+	}
+
+	// server-2's second step is cancelled while in flight, and its first step is undone
+	if count := strings.Count(strings.Join(fake.executed, ","), "server-2"); count != 1 {
+		t.Errorf("Expected server-2 to complete only its first step, ran %d: %v", count, fake.executed)
+	}
+	if !contains(fake.rolledBack, "server-2/first") || contains(fake.rolledBack, "server-2/second") {
+		t.Errorf("Expected server-2 to roll back only its first step, got %v", fake.rolledBack)
+	}
+
+	result := failedResult(t, err)
+	if result.ServersFailed != 1 || result.ServersCancelled != 1 {
+		t.Errorf("Expected 1 failed and 1 cancelled server, got %d failed and %d cancelled", result.ServersFailed, result.ServersCancelled)
+	}
+	var cancelled *models.ExecutionResult
+	for i := range result.Results {
+		if result.Results[i].ServerID == "server-2" {
+			cancelled = &result.Results[i]
+		}
+	}
+	if cancelled == nil || !cancelled.Cancelled || cancelled.Rollback == nil || !cancelled.Rollback.Success {
+		t.Errorf("Expected server-2 to be reported cancelled and rolled back, got %+v", cancelled)
+	}
+}
+
+func TestOrchestrationWorkflow_RollingCancelsBatchOnMaxFailures(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-1": true}}
+	env := newTestEnv(fake)
+	slowStep(env, fake, "server-2", "second")
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4"},
+		Steps:   twoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:      "Rolling",
+			BatchSize: 3,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected workflow to fail once max failures was exceeded")
+	}
+
+	if contains(fake.executed, "server-4") {
+		t.Errorf("Expected the next batch not to start, got %v", fake.executed)
+	}
+	// server-3 completed before the threshold was crossed and is rolled back by the orchestrator
+	if !contains(fake.rolledBack, "server-3/second") || !contains(fake.rolledBack, "server-2/first") {
+		t.Errorf("Expected server-2 and server-3 to be rolled back, got %v", fake.rolledBack)
+	}
+
+	result := failedResult(t, err)
+	if result.ServersCancelled != 1 {
+		t.Errorf("Expected 1 cancelled server, got %d", result.ServersCancelled)
+	}
+}
+
+func TestOrchestrationWorkflow_MaxFailurePercent(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true, "server-4": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3", "server-4", "server-5", "server-6"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:              "Sequential",
+			MaxFailurePercent: 25,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	err := env.GetWorkflowError()
+	if err == nil {
+		t.Fatal("Expected workflow to fail once the failure percentage was exceeded")
+	}
+	if !strings.Contains(err.Error(), "exceeded max failure percent") {
+		t.Errorf("Expected failure percent error, got: %v", err)
+	}
+
+	// server-2 alone is 1 of 6 (under 25%), server-4 makes it 2 of 6
+	if contains(fake.executed, "server-5") {
+		t.Errorf("Expected rollout to stop after server-4, got %v", fake.executed)
+	}
+	if !contains(fake.executed, "server-4") {
+		t.Errorf("Expected server-3 and server-4 to run after the first failure, got %v", fake.executed)
+	}
+}
+
+func TestOrchestrationWorkflow_Notifications(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers: []string{"server-1", "server-2", "server-3"},
+		Steps:   echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:      "Rolling",
+			BatchSize: 1,
+		},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected workflow to fail")
+	}
+
+	// Events are delivered concurrently; their IDs carry the order they were sent in
+	events := append([]notify.Event(nil), fake.events...)
+	sequence := func(e notify.Event) int {
+		n, _ := strconv.Atoi(e.ID[strings.LastIndex(e.ID, "-")+1:])
+		return n
+	}
+	sort.Slice(events, func(i, j int) bool { return sequence(events[i]) < sequence(events[j]) })
+
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Type+"/"+e.ServerID)
+	}
+	expected := []string{
+		"orchestration.started/",
+		"batch.completed/",
+		"server.failed/server-2",
+		"batch.completed/",
+		"rollback.started/server-1",
+		"orchestration.completed/",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+
+	completed := events[len(events)-1]
+	if completed.Result == nil || completed.Result.ServersFailed != 1 || completed.Result.ServersPatched != 1 || completed.Result.ServersRolledBack != 1 || completed.Error == "" {
+		t.Errorf("Expected a summary of the failed result on orchestration.completed, got %+v", completed)
+	}
+	if data, _ := json.Marshal(completed); strings.Contains(string(data), "stepsExecuted") {
+		t.Errorf("Expected orchestration.completed to leave out the server results, got %s", data)
+	}
+	if events[3].Batch != 2 || events[3].TotalBatches != 3 {
+		t.Errorf("Expected batch 2 of 3, got %+v", events[3])
+	}
+	if events[0].OrchestrationID == "" || events[0].ID == events[1].ID {
+		t.Errorf("Expected events to identify the orchestration uniquely, got %+v", events[:2])
+	}
+}
+
+func TestOrchestrationWorkflow_RecordsAudit(t *testing.T) {
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-2": true}}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"server-1", "server-2"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Sequential"},
+		Requester:       "alice",
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected workflow to fail")
+	}
+
+	planID := fake.auditInfo[0].PlanID
+	for _, info := range fake.auditInfo {
+		if info.PlanID == "" || info.PlanID != planID || info.Requester != "alice" {
+			t.Errorf("Expected every step to be audited with the plan and requester, got %+v", info)
+		}
+	}
+
+	var got []string
+	for _, record := range fake.audits {
+		got = append(got, record.Action+"/"+record.ServerID+"/"+record.Step+"/"+record.Result)
+		if record.PlanID != planID || record.Requester != "alice" || record.Phase != audit.PhaseReported {
+			t.Errorf("Expected a reported record for the plan, got %+v", record)
+		}
+	}
+	expected := []string{
+		"execute/server-1/hello/succeeded",
+		"execute/server-2/hello/failed",
+		"rollback/server-1/hello/succeeded",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected audit records %v, got %v", expected, got)
+	}
+
+	executed := fake.audits[0]
+	if executed.StepType != "echo" || executed.ParamsHash == "" || executed.OutputDigest == "" {
+		t.Errorf("Expected the step type and digests, got %+v", executed)
+	}
+	if executed.StartedAt == nil || executed.FinishedAt == nil {
+		t.Errorf("Expected the step start and end times, got %+v", executed)
+	}
+}
+
+func TestOrchestrationWorkflow_RecordsServerMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	var suite testsuite.WorkflowTestSuite
+	handler, closer := metrics.NewHandler(registry)
+	suite.SetMetricsHandler(handler)
+	fake := &fakeStepActivities{failOn: map[string]bool{"server-3": true}}
+	env := newTestEnvWithSuite(&suite, fake)
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"server-1", "server-2", "server-3"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Rolling", BatchSize: 1, MaxFailures: 1},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	closer.Close()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	servers := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != metrics.Servers+"_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels[metrics.TagStrategy] == "Rolling" {
+				servers[labels[metrics.TagOutcome]] += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	if servers[metrics.OutcomePatched] != 2 || servers[metrics.OutcomeFailed] != 1 {
+		t.Errorf("Expected 2 patched and 1 failed server, got %v", servers)
+	}
+}
+
+func TestOrchestrationWorkflow_SingleTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracingInterceptor, err := temporalotel.NewTracingInterceptor(temporalotel.TracerOptions{Tracer: provider.Tracer("test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{tracingInterceptor}})
+
+	req := models.ExecutionRequest{
+		Servers:         []string{"server-1", "server-2"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Parallel"},
+	}
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	spans := recorder.Ended()
+	for _, span := range spans {
+		counts[span.Name()]++
+		if span.SpanContext().TraceID() != spans[0].SpanContext().TraceID() {
+			t.Errorf("Expected a single trace, %s is in another", span.Name())
+		}
+	}
+	expected := map[string]int{
+		"RunWorkflow:OrchestrationWorkflow":   1,
+		"RunWorkflow:ServerExecutionWorkflow": 2,
+		"RunActivity:ExecuteStep":             2,
+	}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("Expected %d %s spans, got %v", count, name, counts)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package workflows
+
+import (
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// QueryProgress is the query type answered by OrchestrationWorkflow
+// (models.OrchestrationProgress) and ServerExecutionWorkflow (models.ExecutionProgress)
+const QueryProgress = "progress"
+
+// currentProgress is the query handler for OrchestrationWorkflow
+func (s *orchestrationState) currentProgress() (models.OrchestrationProgress, error) {
+	progress := s.progress
+	progress.Paused = s.paused && s.abort == nil
+	progress.Servers = append([]models.ServerProgress(nil), s.progress.Servers...)
+	return progress, nil
+}
+
+func (s *orchestrationState) setPhase(phase string) {
+	s.progress.Phase = phase
+}
+
+func (s *orchestrationState) batchStarted(batch int, totalBatches int) {
+	s.progress.CurrentBatch = batch
+	s.progress.TotalBatches = totalBatches
+}
+
+func (s *orchestrationState) serverStarted(serverID string, workflowID string) {
+	s.updateServer(serverID, models.StatusRunning, "")
+	if i, ok := s.serverIndex[serverID]; ok {
+		s.progress.Servers[i].WorkflowID = workflowID
+	}
+}
+
+func (s *orchestrationState) serverCompleted(result models.ExecutionResult) {
+	if result.Success {
+		s.updateServer(result.ServerID, models.StatusSucceeded, "")
+	} else if result.Cancelled {
+		s.updateServer(result.ServerID, models.StatusCancelled, result.Error)
+	} else {
+		s.updateServer(result.ServerID, models.StatusFailed, result.Error)
+	}
+}
+
+func (s *orchestrationState) serverSkipped(result models.ExecutionResult) {
+	s.updateServer(result.ServerID, models.StatusSkipped, result.Error)
+}
+
+func (s *orchestrationState) serverRolledBack(serverID string, err error) {
+	if err != nil {
+		s.updateServer(serverID, models.StatusRollbackFailed, err.Error())
+		return
+	}
+	s.updateServer(serverID, models.StatusRolledBack, "")
+}
+
+func (s *orchestrationState) updateServer(serverID string, status string, errMsg string) {
+	i, ok := s.serverIndex[serverID]
+	if !ok {
+		return
+	}
+	s.progress.Servers[i].Status = status
+	s.progress.Servers[i].Error = errMsg
+}
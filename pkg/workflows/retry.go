@@ -0,0 +1,60 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// prepareRetry builds the request for a retry of a previous orchestration. The
+// retry keeps the original steps and strategy and targets only the servers that
+// did not succeed or were rolled back, in their original order.
+func prepareRetry(ctx workflow.Context, ref models.OrchestrationRef) (models.ExecutionRequest, error) {
+	logger := workflow.GetLogger(ctx)
+
+	activityOptions := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    3,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, activityOptions)
+
+	var record models.OrchestrationRecord
+	if err := workflow.ExecuteActivity(ctx, "LoadOrchestration", ref).Get(ctx, &record); err != nil {
+		return models.ExecutionRequest{}, fmt.Errorf("failed to load orchestration %s: %w", ref.WorkflowID, err)
+	}
+
+	succeeded := make(map[string]bool)
+	for _, result := range record.Result.Results {
+		if result.Success {
+			succeeded[result.ServerID] = true
+		}
+	}
+
+	// Servers the orchestrator rolled back are no longer patched
+	for _, rollback := range record.Result.Rollbacks {
+		delete(succeeded, rollback.ServerID)
+	}
+
+	req := record.Request
+	req.RetryOf = &record.Ref
+	req.Servers = nil
+	for _, serverID := range record.Request.Servers {
+		if !succeeded[serverID] {
+			req.Servers = append(req.Servers, serverID)
+		}
+	}
+
+	logger.Info("Retrying orchestration", "workflowID", record.Ref.WorkflowID, "runID", record.Ref.RunID,
+		"servers", len(req.Servers), "skipped", len(record.Request.Servers)-len(req.Servers))
+
+	return req, nil
+}
@@ -0,0 +1,135 @@
+package workflows
+
+import (
+	"fmt"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// hasStepDependencies reports whether any step declares dependsOn, in which
+// case the steps form a graph instead of running in order
+func hasStepDependencies(steps []models.StepDefinition) bool {
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// runGraph executes the steps as a dependency graph: every step starts as soon
+// as all of its dependencies have succeeded or were skipped by their
+// condition, so independent steps run concurrently. A step whose dependency
+// failed or could not run is skipped. Once
+// a required step fails no further steps are started, and the steps applied so
+// far are rolled back in reverse completion order after the running ones finish.
+func (e *serverExecution) runGraph(ctx workflow.Context) error {
+	steps := e.input.Steps
+
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		index[step.Name] = i
+	}
+
+	const (
+		pending = iota
+		running
+		finished
+	)
+	states := make([]int, len(steps))
+	// steps that succeeded or were skipped by their condition
+	satisfied := make([]bool, len(steps))
+	inFlight := 0
+	var failure error
+
+	// The selector waits on the disconnected context so that steps in flight
+	// are still collected after the workflow is cancelled
+	selector := workflow.NewSelector(e.stepCtx)
+
+	for {
+		// Start or skip every pending step whose dependencies have finished,
+		// repeating since a skipped step can settle its own dependents
+		for progressed := true; progressed && failure == nil && ctx.Err() == nil; {
+			progressed = false
+			for i, step := range steps {
+				if states[i] != pending {
+					continue
+				}
+
+				ready := true
+				unmet := ""
+				for _, dep := range step.DependsOn {
+					if states[index[dep]] != finished {
+						ready = false
+						break
+					}
+					if unmet == "" && !satisfied[index[dep]] {
+						unmet = dep
+					}
+				}
+				if !ready {
+					continue
+				}
+
+				progressed = true
+				if unmet != "" {
+					states[i] = finished
+					if err := e.skipStep(ctx, i, fmt.Sprintf("dependency %s did not succeed", unmet)); err != nil {
+						failure = err
+						break
+					}
+					continue
+				}
+
+				run, err := e.prepareStep(i)
+				if err != nil {
+					states[i] = finished
+					if failure = e.completeStep(ctx, i, nil, err); failure != nil {
+						break
+					}
+					continue
+				}
+				if !run {
+					states[i] = finished
+					satisfied[i] = true
+					e.conditionNotMet(ctx, i)
+					continue
+				}
+
+				states[i] = running
+				inFlight++
+				selector.AddFuture(e.startStep(ctx, i), func(f workflow.Future) {
+					inFlight--
+					states[i] = finished
+
+					var metadata map[string]interface{}
+					err := f.Get(e.stepCtx, &metadata)
+					satisfied[i] = err == nil
+					if stepFailure := e.completeStep(ctx, i, metadata, err); stepFailure != nil && failure == nil {
+						failure = stepFailure
+					}
+				})
+			}
+		}
+
+		if inFlight == 0 {
+			break
+		}
+		selector.Select(e.stepCtx)
+	}
+
+	if failure != nil && ctx.Err() == nil {
+		return e.fail(failure)
+	}
+
+	for i := range steps {
+		if states[i] != finished || ctx.Err() != nil {
+			// Only a cancellation leaves steps that never started, and a
+			// failure after a cancellation comes from the steps it stopped
+			return e.cancel(ctx)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package workflows
+
+import (
+	"fmt"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// failureBudget enforces the failure thresholds of a rollout strategy. Every
+// strategy records each server as it completes and stops the rollout as soon
+// as exceeded returns an error.
+type failureBudget struct {
+	strategy models.RolloutStrategy
+	total    int
+	failures int
+	// outcomes of the most recent completions (true for a failure), oldest
+	// first, holding at most FailureWindow.Size entries
+	recent []bool
+}
+
+func newFailureBudget(strategy models.RolloutStrategy, total int) *failureBudget {
+	return &failureBudget{strategy: strategy, total: total}
+}
+
+// record counts a completed server. Servers cancelled by the orchestrator are
+// not failures of their own and are ignored.
+func (b *failureBudget) record(result models.ExecutionResult) {
+	if result.Cancelled {
+		return
+	}
+
+	failed := !result.Success
+	if failed {
+		b.failures++
+	}
+
+	if w := b.strategy.FailureWindow; w != nil {
+		b.recent = append(b.recent, failed)
+		if len(b.recent) > w.Size {
+			b.recent = b.recent[1:]
+		}
+	}
+}
+
+// exceeded returns an error describing the first threshold that has been crossed
+func (b *failureBudget) exceeded() error {
+	s := b.strategy
+
+	// A zero MaxFailures means "stop on the first failure" only when no
+	// percentage-based threshold is configured
+	percentBased := s.MaxFailurePercent > 0 || s.FailureWindow != nil
+	if s.MaxFailures > 0 || (s.MaxFailures == 0 && !percentBased) {
+		if b.failures > s.MaxFailures {
+			return fmt.Errorf("exceeded max failures: %d > %d", b.failures, s.MaxFailures)
+		}
+	}
+
+	if s.MaxFailurePercent > 0 && b.failures*100 > s.MaxFailurePercent*b.total {
+		return fmt.Errorf("exceeded max failure percent: %d of %d servers failed (> %d%%)", b.failures, b.total, s.MaxFailurePercent)
+	}
+
+	// The window rate is always taken over the full window size, so a few early
+	// failures do not trip it before enough servers have completed
+	if w := s.FailureWindow; w != nil {
+		failures := 0
+		for _, failed := range b.recent {
+			if failed {
+				failures++
+			}
+		}
+		if failures*100 > w.MaxFailurePercent*w.Size {
+			return fmt.Errorf("exceeded failure window: %d of the last %d servers failed (> %d%%)", failures, w.Size, w.MaxFailurePercent)
+		}
+	}
+
+	return nil
+}
+
+// validateFailureThresholds checks the percentage-based thresholds of a strategy
+func validateFailureThresholds(s models.RolloutStrategy) error {
+	if s.MaxFailurePercent < 0 || s.MaxFailurePercent > 100 {
+		return fmt.Errorf("invalid max failure percent: %d (must be between 0 and 100)", s.MaxFailurePercent)
+	}
+
+	if w := s.FailureWindow; w != nil {
+		if w.Size <= 0 {
+			return fmt.Errorf("invalid failure window size: %d (must be greater than 0)", w.Size)
+		}
+		if w.MaxFailurePercent < 0 || w.MaxFailurePercent > 100 {
+			return fmt.Errorf("invalid failure window percent: %d (must be between 0 and 100)", w.MaxFailurePercent)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+func TestFailureBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy models.RolloutStrategy
+		total    int
+		outcomes string // one character per completed server: s succeeded, f failed, c cancelled
+		expected string // substring of the error, empty if no threshold is exceeded
+	}{
+		{name: "default stops on first failure", total: 10, outcomes: "sf", expected: "max failures: 1 > 0"},
+		{name: "max failures", strategy: models.RolloutStrategy{MaxFailures: 2}, total: 10, outcomes: "ffs"},
+		{name: "max failures exceeded", strategy: models.RolloutStrategy{MaxFailures: 2}, total: 10, outcomes: "fff", expected: "max failures: 3 > 2"},
+		{name: "negative max failures disables", strategy: models.RolloutStrategy{MaxFailures: -1}, total: 3, outcomes: "fff"},
+		{name: "cancelled servers are ignored", total: 10, outcomes: "scc"},
+		{name: "percent replaces zero max failures", strategy: models.RolloutStrategy{MaxFailurePercent: 20}, total: 10, outcomes: "ffsss"},
+		{name: "percent exceeded", strategy: models.RolloutStrategy{MaxFailurePercent: 20}, total: 10, outcomes: "ffsf", expected: "3 of 10 servers failed (> 20%)"},
+		{name: "percent with max failures", strategy: models.RolloutStrategy{MaxFailures: 1, MaxFailurePercent: 50}, total: 10, outcomes: "ff", expected: "max failures: 2 > 1"},
+		{
+			name:     "window tolerates failures spread out",
+			strategy: models.RolloutStrategy{FailureWindow: &models.FailureWindow{Size: 4, MaxFailurePercent: 25}},
+			total:    20,
+			outcomes: "fsssfsssf",
+		},
+		{
+			name:     "window exceeded",
+			strategy: models.RolloutStrategy{FailureWindow: &models.FailureWindow{Size: 4, MaxFailurePercent: 25}},
+			total:    20,
+			outcomes: "fsssfsf",
+			expected: "2 of the last 4 servers failed (> 25%)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newFailureBudget(tt.strategy, tt.total)
+			for i, outcome := range tt.outcomes {
+				budget.record(models.ExecutionResult{
+					ServerID:  "server-" + string(rune('a'+i)),
+					Success:   outcome == 's',
+					Cancelled: outcome == 'c',
+				})
+			}
+
+			err := budget.exceeded()
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no threshold exceeded, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateFailureThresholds(t *testing.T) {
+	invalid := []models.RolloutStrategy{
+		{MaxFailurePercent: 101},
+		{FailureWindow: &models.FailureWindow{Size: 0, MaxFailurePercent: 10}},
+		{FailureWindow: &models.FailureWindow{Size: 10, MaxFailurePercent: -1}},
+	}
+	for _, strategy := range invalid {
+		if err := validateFailureThresholds(strategy); err == nil {
+			t.Errorf("Expected validation error for %+v", strategy)
+		}
+	}
+
+	valid := models.RolloutStrategy{MaxFailurePercent: 10, FailureWindow: &models.FailureWindow{Size: 50, MaxFailurePercent: 10}}
+	if err := validateFailureThresholds(valid); err != nil {
+		t.Errorf("Expected valid thresholds, got: %v", err)
+	}
+}
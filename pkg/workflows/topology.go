@@ -0,0 +1,120 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+// topologyExecution rolls out in batches that take at most MaxUnavailable
+// servers from each group of servers sharing the GroupBy labels, so that no zone
+// or rack loses more than that many hosts at once. With SerialBy set, every
+// group of that label (e.g. a zone) is finished before the next one starts.
+func topologyExecution(ctx workflow.Context, req models.ExecutionRequest, budget *failureBudget, state *orchestrationState) ([]models.ExecutionResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	policy := req.RolloutStrategy.Topology
+	if policy == nil || len(policy.GroupBy) == 0 {
+		return nil, fmt.Errorf("topology strategy requires topology.groupBy")
+	}
+	if policy.MaxUnavailable < 0 {
+		return nil, fmt.Errorf("invalid topology maxUnavailable: %d (must be 0 or greater)", policy.MaxUnavailable)
+	}
+
+	batches := topologyBatches(req.Servers, req.ServerLabels, *policy, req.RolloutStrategy.BatchSize)
+
+	logger.Info("Starting topology execution", "servers", len(req.Servers), "groupBy", policy.GroupBy,
+		"maxUnavailable", policy.MaxUnavailable, "serialBy", policy.SerialBy, "batches", len(batches))
+
+	return batchedExecution(ctx, req, batches, budget, state)
+}
+
+// topologyBatches splits the servers into batches holding at most
+// MaxUnavailable servers of each group (1 by default) and, when batchSize is
+// set, at most batchSize servers overall. Groups, and the servers within them,
+// keep the order in which they first appear in servers.
+func topologyBatches(servers []string, labels map[string]map[string]string, policy models.TopologyPolicy, batchSize int) [][]string {
+	maxUnavailable := policy.MaxUnavailable
+	if maxUnavailable == 0 {
+		maxUnavailable = 1
+	}
+
+	var serialKeys []string
+	if policy.SerialBy != "" {
+		serialKeys = []string{policy.SerialBy}
+	}
+
+	var batches [][]string
+	for _, partition := range groupServers(servers, labels, serialKeys) {
+		groups := groupServers(partition, labels, policy.GroupBy)
+		batches = append(batches, drainGroups(groups, maxUnavailable, batchSize)...)
+	}
+	return batches
+}
+
+// drainGroups takes up to maxUnavailable servers from each group per batch.
+// When batchSize cuts a batch short, the next batch starts with the group that
+// was left out so that every group keeps making progress.
+func drainGroups(groups [][]string, maxUnavailable int, batchSize int) [][]string {
+	remaining := 0
+	for _, group := range groups {
+		remaining += len(group)
+	}
+
+	var batches [][]string
+	taken := make([]int, len(groups))
+	start := 0
+	for remaining > 0 {
+		var batch []string
+		next := start
+		for k := 0; k < len(groups); k++ {
+			g := (start + k) % len(groups)
+			if batchSize > 0 && len(batch) >= batchSize {
+				break
+			}
+
+			count := len(groups[g]) - taken[g]
+			if count > maxUnavailable {
+				count = maxUnavailable
+			}
+			if batchSize > 0 && len(batch)+count > batchSize {
+				count = batchSize - len(batch)
+			}
+
+			batch = append(batch, groups[g][taken[g]:taken[g]+count]...)
+			taken[g] += count
+			remaining -= count
+			next = (g + 1) % len(groups)
+		}
+		batches = append(batches, batch)
+		start = next
+	}
+	return batches
+}
+
+// groupServers splits servers by the values of the given label keys. Servers
+// without a label are grouped under an empty value for it.
+func groupServers(servers []string, labels map[string]map[string]string, keys []string) [][]string {
+	var groups [][]string
+	index := make(map[string]int)
+
+	for _, serverID := range servers {
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = labels[serverID][key]
+		}
+		groupKey := strings.Join(values, "/")
+
+		i, ok := index[groupKey]
+		if !ok {
+			i = len(groups)
+			index[groupKey] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], serverID)
+	}
+	return groups
+}
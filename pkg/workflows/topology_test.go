@@ -0,0 +1,129 @@
+package workflows
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/melslow/kitsune/pkg/models"
+)
+
+func topologyLabels() map[string]map[string]string {
+	return map[string]map[string]string{
+		"a1": {"zone": "a", "rack": "1"},
+		"a2": {"zone": "a", "rack": "1"},
+		"a3": {"zone": "a", "rack": "2"},
+		"b1": {"zone": "b", "rack": "1"},
+		"b2": {"zone": "b", "rack": "1"},
+		"b3": {"zone": "b", "rack": "1"},
+	}
+}
+
+func TestTopologyBatches(t *testing.T) {
+	servers := []string{"a1", "a2", "a3", "b1", "b2", "b3"}
+
+	tests := []struct {
+		name      string
+		policy    models.TopologyPolicy
+		batchSize int
+		expected  [][]string
+	}{
+		{
+			name:     "one server per zone",
+			policy:   models.TopologyPolicy{GroupBy: []string{"zone"}},
+			expected: [][]string{{"a1", "b1"}, {"a2", "b2"}, {"a3", "b3"}},
+		},
+		{
+			name:     "two servers per zone",
+			policy:   models.TopologyPolicy{GroupBy: []string{"zone"}, MaxUnavailable: 2},
+			expected: [][]string{{"a1", "a2", "b1", "b2"}, {"a3", "b3"}},
+		},
+		{
+			name:     "one server per rack",
+			policy:   models.TopologyPolicy{GroupBy: []string{"zone", "rack"}},
+			expected: [][]string{{"a1", "a3", "b1"}, {"a2", "b2"}, {"b3"}},
+		},
+		{
+			name:     "racks one zone at a time",
+			policy:   models.TopologyPolicy{GroupBy: []string{"rack"}, SerialBy: "zone"},
+			expected: [][]string{{"a1", "a3"}, {"a2"}, {"b1"}, {"b2"}, {"b3"}},
+		},
+		{
+			name:      "batch size rotates groups",
+			policy:    models.TopologyPolicy{GroupBy: []string{"zone", "rack"}},
+			batchSize: 2,
+			expected:  [][]string{{"a1", "a3"}, {"b1", "a2"}, {"b2"}, {"b3"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := topologyBatches(servers, topologyLabels(), tt.policy, tt.batchSize)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected batches %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTopologyBatches_UnlabelledServers(t *testing.T) {
+	got := topologyBatches([]string{"x", "a1", "y"}, topologyLabels(), models.TopologyPolicy{GroupBy: []string{"zone"}}, 0)
+	expected := [][]string{{"x", "a1"}, {"y"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, got)
+	}
+}
+
+func TestOrchestrationWorkflow_Topology(t *testing.T) {
+	fake := &fakeStepActivities{}
+	env := newTestEnv(fake)
+
+	req := models.ExecutionRequest{
+		Servers:      []string{"a1", "a2", "a3", "b1", "b2", "b3"},
+		ServerLabels: topologyLabels(),
+		Steps:        echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{
+			Type:     "Topology",
+			Topology: &models.TopologyPolicy{GroupBy: []string{"zone"}, MaxUnavailable: 2},
+		},
+	}
+
+	env.ExecuteWorkflow(OrchestrationWorkflow, req)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	var result models.OrchestrationResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	if result.ServersPatched != len(req.Servers) {
+		t.Errorf("Expected %d servers patched, got %d", len(req.Servers), result.ServersPatched)
+	}
+
+	value, err := env.QueryWorkflow(QueryProgress)
+	if err != nil {
+		t.Fatalf("Failed to query progress: %v", err)
+	}
+	var progress models.OrchestrationProgress
+	if err := value.Get(&progress); err != nil {
+		t.Fatalf("Failed to decode progress: %v", err)
+	}
+	if progress.TotalBatches != 2 {
+		t.Errorf("Expected 2 batches, got %d", progress.TotalBatches)
+	}
+}
+
+func TestOrchestrationWorkflow_TopologyRequiresGroupBy(t *testing.T) {
+	env := newTestEnv(&fakeStepActivities{})
+
+	env.ExecuteWorkflow(OrchestrationWorkflow, models.ExecutionRequest{
+		Servers:         []string{"a1"},
+		Steps:           echoSteps(),
+		RolloutStrategy: models.RolloutStrategy{Type: "Topology"},
+	})
+
+	if err := env.GetWorkflowError(); err == nil {
+		t.Fatal("Expected workflow to fail without topology.groupBy")
+	}
+}